@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// apiIndexName is the path of the API index, relative to the site root.
+const apiIndexName = "api/index.json"
+
+type apiIndex struct {
+	Modules []apiModule `json:"modules"`
+}
+
+type apiModule struct {
+	Prefix   string   `json:"prefix"`
+	VCS      string   `json:"vcs"`
+	Repo     string   `json:"repo"`
+	Subdir   string   `json:"subdir,omitempty"`
+	Latest   string   `json:"latest,omitempty"`
+	Packages []string `json:"packages"`
+}
+
+// apiIndexFile returns the API index describing the import prefixes served
+// by the site: the base import prefix and those of the workspace modules
+// with go-import tags of their own, each with its repository root and
+// subdirectory. The entries of other import prefixes are kept from the
+// index previously generated at existing, if any. rels, the unprefixed
+// version tags, only give the latest version of the base import prefix.
+func apiIndexFile(s site, existing string, rels []release) (File, error) {
+	var index apiIndex
+	b, err := ioutil.ReadFile(existing)
+	switch {
+	case err == nil:
+		var old apiIndex
+		if err := json.Unmarshal(b, &old); err != nil {
+			return File{}, err
+		}
+		served := make(map[string]bool)
+		for gi := range s.packages {
+			served[gi.ImportPrefix] = true
+		}
+		for _, m := range old.Modules {
+			if m.Prefix != s.importPrefix && !served[m.Prefix] {
+				index.Modules = append(index.Modules, m)
+			}
+		}
+	case !os.IsNotExist(err):
+		return File{}, err
+	}
+
+	packages := s.packages
+	if _, ok := packages[s.goImport]; !ok {
+		// Describe the base import prefix even without packages.
+		packages = map[GoImport][]string{s.goImport: nil}
+		for gi, pkgs := range s.packages {
+			packages[gi] = pkgs
+		}
+	}
+	for gi, pkgs := range packages {
+		m := apiModule{
+			Prefix:   gi.ImportPrefix,
+			VCS:      gi.VCS,
+			Repo:     gi.RepoRoot,
+			Subdir:   gi.Subdir,
+			Packages: pkgs,
+		}
+		if gi.ImportPrefix == s.importPrefix {
+			m.Latest = latestVersion(rels)
+		}
+		index.Modules = append(index.Modules, m)
+	}
+	sort.Slice(index.Modules, func(i, j int) bool {
+		return index.Modules[i].Prefix < index.Modules[j].Prefix
+	})
+	return jsonFile(path.Join(s.host, apiIndexName), index)
+}
+
+// latestVersion returns the version go get would choose as latest among
+// rels, which are sorted newest first: the newest release version, or the
+// newest prerelease version if there are no release versions.
+func latestVersion(rels []release) string {
+	for _, r := range rels {
+		if !strings.Contains(r.Version, "-") {
+			return r.Version
+		}
+	}
+	if len(rels) > 0 {
+		return rels[0].Version
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAPIIndexWorkspaceModules(t *testing.T) {
+	base := GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "https://github.com/u/r"}
+	ws := GoImport{ImportPrefix: "other.org/m", VCS: "git", RepoRoot: "https://github.com/u/r", Subdir: "m"}
+	s := newSite("example.org/r")
+	s.goImport = base
+	s.packages = map[GoImport][]string{
+		base: {"example.org/r", "example.org/r/a"},
+		ws:   {"other.org/m", "other.org/m/sub"},
+	}
+	f, err := apiIndexFile(s, filepath.Join(t.TempDir(), "index.json"), []release{{Version: "v1.0.0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got apiIndex
+	if err := json.Unmarshal(f.contents.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := apiIndex{Modules: []apiModule{
+		{Prefix: "example.org/r", VCS: "git", Repo: "https://github.com/u/r", Latest: "v1.0.0", Packages: []string{"example.org/r", "example.org/r/a"}},
+		{Prefix: "other.org/m", VCS: "git", Repo: "https://github.com/u/r", Subdir: "m", Packages: []string{"other.org/m", "other.org/m/sub"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("API index = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// isArchive reports whether the output named by -o is an archive, a
+// gzipped tar file or a zip file, rather than a directory.
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".zip")
+}
+
+// writeArchive writes the files to the archive, of the kind its name says,
+// in order of their paths, with the modification time mtime.
+func writeArchive(name string, files []File, mtime time.Time) (err error) {
+	sorted := make([]*File, len(files))
+	for i := range files {
+		sorted[i] = &files[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, permFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if strings.HasSuffix(name, ".zip") {
+		return writeZip(f, sorted, mtime)
+	}
+	return writeTarGz(f, sorted, mtime)
+}
+
+func writeTarGz(w io.Writer, files []*File, mtime time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:    file.path,
+			Mode:    permFile,
+			Size:    int64(file.contents.Len()),
+			ModTime: mtime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.contents.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeZip(w io.Writer, files []*File, mtime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		hdr := &zip.FileHeader{Name: file.path, Method: zip.Deflate, Modified: mtime}
+		hdr.SetMode(permFile)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.contents.Bytes()); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// An azureRepo is a git repository hosted by Azure DevOps.
+type azureRepo struct {
+	org, project, repo string
+}
+
+// parseAzureURL parses the URLs of Azure DevOps repositories, which come in
+// several forms, and reports whether repoURL is one:
+//
+//	https://[user@]dev.azure.com/org/project/_git/repo
+//	https://org.visualstudio.com/[DefaultCollection/]project/_git/repo
+//	git@ssh.dev.azure.com:v3/org/project/repo
+//	org@vs-ssh.visualstudio.com:v3/org/project/repo
+func parseAzureURL(repoURL string) (azureRepo, bool) {
+	if r, ok := parseSSHURL(repoURL); ok {
+		if r.host != "ssh.dev.azure.com" && r.host != "vs-ssh.visualstudio.com" {
+			return azureRepo{}, false
+		}
+		parts := strings.Split(strings.Trim(r.path, "/"), "/")
+		if len(parts) != 4 || parts[0] != "v3" {
+			return azureRepo{}, false
+		}
+		return azureRepo{parts[1], parts[2], parts[3]}, true
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return azureRepo{}, false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case u.Host == "dev.azure.com":
+		if len(parts) == 4 && parts[2] == "_git" {
+			return azureRepo{parts[0], parts[1], parts[3]}, true
+		}
+	case strings.HasSuffix(u.Host, ".visualstudio.com"):
+		org := strings.TrimSuffix(u.Host, ".visualstudio.com")
+		if len(parts) == 4 && parts[0] == "DefaultCollection" {
+			parts = parts[1:]
+		}
+		if len(parts) == 3 && parts[1] == "_git" {
+			return azureRepo{org, parts[0], parts[2]}, true
+		}
+	}
+	return azureRepo{}, false
+}
+
+// url returns the canonical URL of the repository, which Azure DevOps
+// uses in its own go-import tags.
+func (a azureRepo) url() string {
+	u := url.URL{Scheme: "https", Host: "dev.azure.com", Path: "/" + a.org + "/" + a.project + "/_git/" + a.repo}
+	return u.String()
+}
+
+// azureVersion returns the version query parameter of Azure DevOps for the
+// tree named by ref, or "" for the default branch if its name is unknown.
+func azureVersion(ref treeRef) string {
+	switch {
+	case ref.rev != "":
+		return "GC" + ref.rev
+	case ref.tag != "":
+		return "GT" + ref.tag
+	case ref.branch != "":
+		return "GB" + ref.branch
+	}
+	return ""
+}
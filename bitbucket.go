@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// bitbucketServerURL returns the URL at which Bitbucket Server, or Data
+// Center, shows the repository at repoURL, whose clone URLs have the forms
+//
+//	https://host[/context]/scm/project/repo.git
+//	ssh://git@host:7999/project/repo.git
+//
+// and whose personal repositories have ~user in place of the project.
+func bitbucketServerURL(repoURL string) (string, bool) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", false
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	var context string
+	if i := strings.LastIndex("/"+p, "/scm/"); i >= 0 {
+		context, p = strings.Trim(p[:i], "/"), p[i+len("scm/"):]
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) != 2 {
+		return "", false
+	}
+	browse := "/projects/" + strings.ToUpper(parts[0]) + "/repos/" + parts[1]
+	if strings.HasPrefix(parts[0], "~") {
+		browse = "/users/" + parts[0][1:] + "/repos/" + parts[1]
+	}
+	if context != "" {
+		browse = "/" + context + browse
+	}
+	b := url.URL{Scheme: "https", Host: webHost(u), Path: browse}
+	if u.Scheme == "http" {
+		b.Scheme = "http"
+	}
+	return b.String(), true
+}
+
+// webHost returns the host of the web interface of the server of the
+// repository at u. The port of an SSH URL, such as Bitbucket Server's 7999,
+// is that of the SSH server, so it is dropped.
+func webHost(u *url.URL) string {
+	if u.Scheme == "ssh" || u.Scheme == "git+ssh" {
+		return u.Hostname()
+	}
+	return u.Host
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestBitbucketServerURL(t *testing.T) {
+	tests := []struct {
+		repoURL, want string
+	}{
+		{"https://git.example.com/scm/proj/repo.git", "https://git.example.com/projects/PROJ/repos/repo"},
+		{"https://git.example.com/bitbucket/scm/proj/repo.git", "https://git.example.com/bitbucket/projects/PROJ/repos/repo"},
+		{"https://git.example.com:8443/scm/~jdoe/repo.git", "https://git.example.com:8443/users/jdoe/repos/repo"},
+		{"http://git.example.com/scm/proj/repo.git", "http://git.example.com/projects/PROJ/repos/repo"},
+		{"ssh://git@git.example.com:7999/proj/repo.git", "https://git.example.com/projects/PROJ/repos/repo"},
+		{"ssh://git@git.example.com/~jdoe/repo.git", "https://git.example.com/users/jdoe/repos/repo"},
+	}
+	for _, tt := range tests {
+		got, ok := bitbucketServerURL(tt.repoURL)
+		if !ok || got != tt.want {
+			t.Errorf("bitbucketServerURL(%q) = %q, %v, want %q", tt.repoURL, got, ok, tt.want)
+		}
+	}
+}
+
+func TestBitbucketServerSSHSpec(t *testing.T) {
+	spec := hostGodocSpec("ssh://git@git.example.com:7999/proj/repo.git", "bitbucket-server", treeRef{branch: "main"}, unfetchedBackend{})
+	const want = "https://git.example.com/projects/PROJ/repos/repo/browse{/dir}?at=refs/heads/main"
+	if got := spec.directory(); got != want {
+		t.Errorf("directory = %q, want %q", got, want)
+	}
+}
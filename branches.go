@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A branchPrefix maps a branch of the repository to an additional import
+// prefix, whose pages are generated from the branch's tree.
+type branchPrefix struct {
+	branch, importPrefix string
+}
+
+// branchPrefixes is a flag.Value for the repeatable -branch-prefix flag,
+// whose values have the form "branch=import-prefix".
+type branchPrefixes []branchPrefix
+
+func (b *branchPrefixes) String() string {
+	var s []string
+	for _, p := range *b {
+		s = append(s, p.branch+"="+p.importPrefix)
+	}
+	return strings.Join(s, ",")
+}
+
+func (b *branchPrefixes) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 || i == len(v)-1 {
+		return fmt.Errorf("want branch=import-prefix, got %q", v)
+	}
+	*b = append(*b, branchPrefix{v[:i], v[i+1:]})
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// cacheDir is the directory, given by -cache-dir, in which mirrors of git
+// repositories are kept between runs, if any.
+var cacheDir string
+
+// gitHTTPHeader holds the extra headers given by -git-header.
+var gitHTTPHeader http.Header
+
+// cachedRepo brings the mirror of the repository in the cache up to date,
+// cloning it on the first run, and returns its directory. The mirror is
+// fetched with the git command, to which the credentials metaimport would
+// use are passed.
+func cachedRepo(repoURL string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("git command not found; it is needed for -cache-dir")
+	}
+	if strings.HasPrefix(repoURL, "-") {
+		// Don't let the URL be taken for an option.
+		return "", fmt.Errorf("invalid repository URL %q", repoURL)
+	}
+	env := gitEnv(repoURL)
+	dir := filepath.Join(cacheDir, cacheName(repoURL))
+	if _, err := os.Stat(dir); err == nil {
+		if origin, err := gitOutput(dir, env, "config", "--get", "remote.origin.url"); err != nil || origin != repoURL {
+			// Not a mirror of this repository; start over.
+			debugf("recreating cache %s, a mirror of %q", dir, origin)
+			if err := os.RemoveAll(dir); err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		debugf("creating cache %s", dir)
+		if err := os.MkdirAll(cacheDir, permDir); err != nil {
+			return "", err
+		}
+		err := runGit("", env, "init", "--quiet", "--bare", dir)
+		if err == nil {
+			err = runGit(dir, env, "remote", "add", "--mirror=fetch", "origin", repoURL)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	args := []string{"fetch", "--quiet", "--prune"}
+	if fetchDepth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", fetchDepth))
+	}
+	if err := runGit(dir, env, append(args, "origin")...); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// cacheName returns the name of the directory of the repository in the
+// cache, made from its host and path and a hash of the whole URL, such as
+// "github.com_user_repo-1a2b3c4d.git". The hash tells apart URLs that differ
+// only in the scheme or in characters replaced in the name.
+func cacheName(repoURL string) string {
+	name := repoURL
+	if r, ok := parseSSHURL(repoURL); ok {
+		name = r.host + "/" + strings.TrimPrefix(r.path, "/")
+	} else if u, err := url.Parse(repoURL); err == nil {
+		name = u.Host + u.Path
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(strings.Trim(name, "/"), ".git"))
+	sum := sha256.Sum256([]byte(repoURL))
+	return fmt.Sprintf("%s-%x.git", name, sum[:4])
+}
+
+// gitOutput runs the git command in dir with the extra environment and
+// returns its output, trimmed of surrounding space.
+func gitOutput(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// runGit runs the git command in dir with the extra environment.
+func runGit(dir string, env []string, args ...string) error {
+	debugf("running git %s in %s", strings.Join(args, " "), dir)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// gitEnv returns the environment that passes the extra headers, the proxy,
+// the TLS settings, the access token or .netrc credentials, and the SSH key
+// to the git command fetching the repository. Environment variables rather
+// than arguments are used so that the secrets don't show up in process
+// listings.
+func gitEnv(repoURL string) []string {
+	var config [][2]string
+	if u, err := url.Parse(repoURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && isAuthHost(u) {
+		// Scope the headers to the host, as the global http.extraHeader
+		// would be sent to every URL git fetches.
+		for name, values := range gitHTTPHeader {
+			for _, v := range values {
+				config = append(config, [2]string{"http." + authScope(u) + ".extraHeader", name + ": " + v})
+			}
+		}
+	}
+	if gitProxy != nil {
+		config = append(config, [2]string{"http.proxy", gitProxy.String()})
+	}
+	if gitCACert != "" {
+		// git runs in the repository, so relative paths would be wrong.
+		abs, _ := filepath.Abs(gitCACert)
+		config = append(config, [2]string{"http.sslCAInfo", abs})
+	}
+	if gitInsecure {
+		config = append(config, [2]string{"http.sslVerify", "false"})
+	}
+	if fetchTimeout > 0 {
+		// Abort fetches that transfer nothing for the timeout.
+		config = append(config, [2]string{"http.lowSpeedLimit", "1"}, [2]string{"http.lowSpeedTime", fmt.Sprint(int(fetchTimeout.Seconds() + 0.5))})
+	}
+	if a, ok := httpAuth(repoURL).(*basicAuth); ok {
+		// Scope the credentials to the host, as the global
+		// http.extraHeader would be sent to every URL git fetches.
+		u, _ := url.Parse(repoURL) // parsed by httpAuth
+		creds := base64.StdEncoding.EncodeToString([]byte(a.user + ":" + a.password))
+		config = append(config, [2]string{"http." + authScope(u) + ".extraHeader", "Authorization: Basic " + creds})
+	}
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config))}
+	for i, kv := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+	}
+	// Don't download the files tracked by Git LFS when checking out; only
+	// their pointers are needed.
+	env = append(env, "GIT_LFS_SKIP_SMUDGE=1")
+	if sshKeyFile != "" && os.Getenv("GIT_SSH_COMMAND") == "" {
+		abs, _ := filepath.Abs(sshKeyFile)
+		env = append(env, "GIT_SSH_COMMAND=ssh -o IdentitiesOnly=yes -i '"+strings.Replace(abs, "'", `'\''`, -1)+"'")
+	}
+	return env
+}
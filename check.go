@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const checkHelp = `usage: metaimport check [flags] <domain>
+
+check verifies that the vanity domain is set up to serve the generated
+site, and reports any misconfiguration it finds. 'domain' is the vanity
+domain, or an import path on the domain. The exit status is 1 if any check
+fails.
+
+The checks are:
+   dns    The domain resolves, to the expected canonical name and addresses.
+   tls    The domain serves https with a certificate chain that verifies for
+          the domain and doesn't expire soon.
+   proxy  With -proxy, the import path, taken to be a module path, resolves
+          through proxy.golang.org.
+
+Flags
+   -expect-a      Comma-separated list of the IP addresses the domain should
+                  resolve to (default: any).
+   -expect-cname  Canonical name the domain should be an alias for (default: any).
+   -expiry        Minimum remaining validity of the domain's TLS certificates (default: 336h).
+   -proxy         Check that the module resolves through proxy.golang.org (default: false).
+
+Examples
+   metaimport check -expect-cname user.github.io example.org
+   metaimport check -expect-a 192.0.2.1,192.0.2.2 example.org/myrepo
+   metaimport check -proxy example.org/myrepo
+`
+
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, checkHelp)
+		os.Exit(2)
+	}
+	expectA := fs.String("expect-a", "", "")
+	expectCNAME := fs.String("expect-cname", "", "")
+	expiry := fs.Duration("expiry", 14*24*time.Hour, "")
+	proxy := fs.Bool("proxy", false, "")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+	host := newSite(fs.Arg(0)).host
+
+	var addrs []string
+	if *expectA != "" {
+		addrs = strings.Split(*expectA, ",")
+	}
+
+	type check struct {
+		name string
+		err  error
+	}
+	checks := []check{
+		{"dns", checkDNS(host, *expectCNAME, addrs)},
+		{"tls", checkTLS(host, *expiry)},
+	}
+	if *proxy {
+		checks = append(checks, check{"proxy", checkProxy(fs.Arg(0))})
+	}
+
+	failed := false
+	for _, c := range checks {
+		if c.err != nil {
+			fmt.Printf("FAIL %s: %s\n", c.name, c.err)
+			failed = true
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkDNS checks that host resolves, and that it resolves to the expected
+// canonical name and addresses, if any are specified.
+func checkDNS(host, expectCNAME string, expectAddrs []string) error {
+	var problems []string
+
+	if expectCNAME != "" {
+		cname, err := net.LookupCNAME(host)
+		if err != nil {
+			return fmt.Errorf("looking up CNAME for %s: %s", host, err)
+		}
+		if normalizeDNSName(cname) != normalizeDNSName(expectCNAME) {
+			problems = append(problems, fmt.Sprintf("%s is an alias for %s, expected %s", host, normalizeDNSName(cname), normalizeDNSName(expectCNAME)))
+		}
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return fmt.Errorf("looking up %s: %s", host, err)
+	}
+	if len(expectAddrs) > 0 {
+		got := make(map[string]bool, len(addrs))
+		for _, a := range addrs {
+			got[net.ParseIP(a).String()] = true
+		}
+		want := make(map[string]bool, len(expectAddrs))
+		for _, a := range expectAddrs {
+			ip := net.ParseIP(strings.TrimSpace(a))
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q", a)
+			}
+			want[ip.String()] = true
+		}
+		for a := range want {
+			if !got[a] {
+				problems = append(problems, fmt.Sprintf("%s does not resolve to %s", host, a))
+			}
+		}
+		for a := range got {
+			if !want[a] {
+				problems = append(problems, fmt.Sprintf("%s unexpectedly resolves to %s", host, a))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// checkTLS checks that host serves https with a certificate chain that is
+// valid for host, none of whose certificates expire within window.
+func checkTLS(host string, window time.Duration) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	// Dial verifies the chain and the hostname.
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, "443"), &tls.Config{ServerName: host})
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %s", host, err)
+	}
+	defer conn.Close()
+
+	chains := conn.ConnectionState().VerifiedChains
+	if len(chains) == 0 {
+		return fmt.Errorf("no verified certificate chain for %s", host)
+	}
+	for _, cert := range chains[0] {
+		if left := time.Until(cert.NotAfter); left < window {
+			return fmt.Errorf("certificate %q expires %s, in %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339), left.Truncate(time.Hour))
+		}
+	}
+	return nil
+}
+
+const goProxy = "https://proxy.golang.org"
+
+// checkProxy checks that the module resolves through the public module
+// proxy, which fetches it using the go-import tags served for it.
+func checkProxy(module string) error {
+	_, err := proxyLatest(module)
+	return err
+}
+
+// proxyLatest returns the latest version of the module known to the public
+// module proxy.
+func proxyLatest(module string) (string, error) {
+	escaped, err := escapeModulePath(module)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(goProxy + "/" + escaped + "/@latest")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s does not resolve through %s: %s: %s", module, goProxy, resp.Status, strings.TrimSpace(string(b)))
+	}
+	var info struct{ Version string }
+	if err := json.Unmarshal(b, &info); err != nil {
+		return "", fmt.Errorf("decoding %s response: %s", goProxy, err)
+	}
+	if info.Version == "" {
+		return "", fmt.Errorf("%s returned no version for %s", goProxy, module)
+	}
+	return info.Version, nil
+}
+
+// escapeModulePath escapes a module path for use in module proxy URLs,
+// replacing each upper-case letter by an exclamation mark followed by the
+// letter's lower-case equivalent. See 'go help goproxy'.
+func escapeModulePath(module string) (string, error) {
+	var b strings.Builder
+	for _, r := range module {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+		case r == '!' || r > unicode.MaxASCII:
+			return "", fmt.Errorf("invalid module path %q", module)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
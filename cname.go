@@ -0,0 +1,13 @@
+package main
+
+import "path"
+
+// cnameFiles returns the CNAME file that GitHub Pages reads the custom
+// domain of the site from, the vanity domain, and an empty .nojekyll file,
+// which stops GitHub Pages from building the site with Jekyll, which would
+// leave out the pages in directories beginning with "_".
+func cnameFiles(s site) []File {
+	f := File{path: path.Join(s.host, "CNAME")}
+	f.contents.WriteString(s.host + "\n")
+	return []File{f, {path: path.Join(s.host, ".nojekyll")}}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// A codeCommitRepo is a git repository hosted by AWS CodeCommit.
+type codeCommitRepo struct {
+	region, repo string
+}
+
+// parseCodeCommitURL parses the https and ssh URLs of CodeCommit
+// repositories, and reports whether repoURL is one:
+//
+//	https://git-codecommit.region.amazonaws.com/v1/repos/repo
+//	ssh://[key-id@]git-codecommit.region.amazonaws.com/v1/repos/repo
+//
+// The hosts of FIPS endpoints, git-codecommit-fips.region.amazonaws.com,
+// are accepted as well.
+func parseCodeCommitURL(repoURL string) (codeCommitRepo, bool) {
+	var host, p string
+	if r, ok := parseSSHURL(repoURL); ok {
+		host, p = r.host, r.path
+	} else if u, err := url.Parse(repoURL); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+		host, p = u.Hostname(), u.Path
+	} else {
+		return codeCommitRepo{}, false
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) != 4 || labels[2] != "amazonaws" || labels[3] != "com" ||
+		(labels[0] != "git-codecommit" && labels[0] != "git-codecommit-fips") {
+		return codeCommitRepo{}, false
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) != 3 || parts[0] != "v1" || parts[1] != "repos" {
+		return codeCommitRepo{}, false
+	}
+	return codeCommitRepo{labels[1], strings.TrimSuffix(parts[2], ".git")}, true
+}
+
+// consoleURL returns the URL of the repository in the AWS console.
+func (c codeCommitRepo) consoleURL() string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   c.region + ".console.aws.amazon.com",
+		Path:   "/codesuite/codecommit/repositories/" + c.repo,
+	}
+	return u.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"go/build"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// commonPlatforms are the GOOS/GOARCH pairs considered by
+// -build-constraints.
+var commonPlatforms = []struct{ goos, goarch string }{
+	{"darwin", "amd64"},
+	{"darwin", "arm64"},
+	{"freebsd", "amd64"},
+	{"js", "wasm"},
+	{"linux", "386"},
+	{"linux", "amd64"},
+	{"linux", "arm"},
+	{"linux", "arm64"},
+	{"wasip1", "wasm"},
+	{"windows", "386"},
+	{"windows", "amd64"},
+	{"windows", "arm64"},
+}
+
+// matchesCommonPlatform reports whether the non-test Go file f would be
+// built on at least one of the common platforms, taking into account its
+// build constraints and file name suffixes. A file whose constraints can't
+// be parsed is taken to match, with a warning.
+func matchesCommonPlatform(f sourceFile) (bool, error) {
+	if strings.HasSuffix(f.name, "_test.go") {
+		return false, nil
+	}
+	contents, err := f.contents()
+	if err != nil {
+		return false, err
+	}
+
+	if isLFSPointer(contents) {
+		// Only the file name suffixes can be taken into account.
+		contents = ""
+	}
+
+	dir, name := path.Split(f.name)
+	for _, p := range commonPlatforms {
+		ctx := build.Default
+		ctx.GOOS = p.goos
+		ctx.GOARCH = p.goarch
+		ctx.CgoEnabled = true
+		ctx.BuildTags = nil
+		ctx.JoinPath = path.Join
+		ctx.OpenFile = func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(contents)), nil
+		}
+		ok, err := ctx.MatchFile(dir, name)
+		if err != nil {
+			// Such as a malformed build constraint. Keep the file rather
+			// than fail, as it may well build once fixed.
+			warnf("%s: %s; assuming it builds", f.name, strings.TrimPrefix(err.Error(), name+": "))
+			return true, nil
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
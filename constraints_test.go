@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestMatchesCommonPlatform(t *testing.T) {
+	for _, tt := range []struct {
+		name, contents string
+		want           bool
+	}{
+		{"a.go", "package a\n", true},
+		{"a_test.go", "package a\n", false},
+		{"a_plan9.go", "package a\n", false},
+		{"a.go", "//go:build plan9\n\npackage a\n", false},
+		{"a.go", "//go:build linux\n\npackage a\n", true},
+		// A malformed constraint isn't fatal.
+		{"a.go", "//go:build linux &&\n\npackage a\n", true},
+	} {
+		contents := tt.contents
+		f := sourceFile{name: "a/" + tt.name, read: func() (string, error) { return contents, nil }}
+		got, err := matchesCommonPlatform(f)
+		if err != nil || got != tt.want {
+			t.Errorf("matchesCommonPlatform(%s, %q) = %v, %v; want %v", tt.name, tt.contents, got, err, tt.want)
+		}
+	}
+}
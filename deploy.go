@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// A deployer publishes the generated site to a hosting provider.
+type deployer interface {
+	// deploy uploads the files in siteDir, the directory served at the
+	// root of the vanity domain, and publishes them.
+	deploy(siteDir string) error
+}
+
+// newDeployer returns the deployer for the named target. Credentials are
+// read from the environment.
+func newDeployer(target, site string) (deployer, error) {
+	switch target {
+	case "netlify":
+		token := os.Getenv("NETLIFY_AUTH_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("NETLIFY_AUTH_TOKEN must be set to deploy to netlify")
+		}
+		if site == "" {
+			return nil, fmt.Errorf("-site must be set to deploy to netlify")
+		}
+		return netlify{http.DefaultClient, token, site}, nil
+	case "cloudflare":
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		account := os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+		if token == "" || account == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_API_TOKEN and CLOUDFLARE_ACCOUNT_ID must be set to deploy to cloudflare")
+		}
+		if site == "" {
+			return nil, fmt.Errorf("-site must be set to deploy to cloudflare")
+		}
+		return cloudflare{http.DefaultClient, token, account, site}, nil
+	default:
+		return nil, fmt.Errorf("unknown deploy target %q", target)
+	}
+}
+
+// siteFiles returns the contents of the regular files in siteDir, keyed by
+// their slash-separated path relative to siteDir, with a leading slash.
+func siteFiles(siteDir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(siteDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(siteDir, p)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files["/"+filepath.ToSlash(rel)] = b
+		return nil
+	})
+	return files, err
+}
+
+// apiRequest performs an authenticated request and decodes the JSON
+// response, if any, into v.
+func apiRequest(client *http.Client, token, method, u string, body io.Reader, contentType string, v interface{}) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, bytes.TrimSpace(b))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// netlify deploys using the file digest method of the Netlify API. See
+// https://docs.netlify.com/api/get-started/#file-digest-method.
+//
+// Netlify deploys are atomic: the new version is published only once all
+// of its files have been uploaded.
+type netlify struct {
+	client *http.Client
+	token  string
+	site   string // site ID or domain
+}
+
+const netlifyAPI = "https://api.netlify.com/api/v1"
+
+type netlifyDeploy struct {
+	ID           string   `json:"id"`
+	State        string   `json:"state"`
+	Required     []string `json:"required"`
+	ErrorMessage string   `json:"error_message"`
+}
+
+func (n netlify) deploy(siteDir string) error {
+	files, err := siteFiles(siteDir)
+	if err != nil {
+		return fmt.Errorf("reading site files: %s", err)
+	}
+
+	digests := make(map[string]string, len(files))
+	byDigest := make(map[string]string, len(files)) // digest -> any path with the digest
+	for p, b := range files {
+		sum := sha1.Sum(b)
+		digest := hex.EncodeToString(sum[:])
+		digests[p] = digest
+		byDigest[digest] = p
+	}
+
+	body, err := json.Marshal(struct {
+		Files map[string]string `json:"files"`
+	}{digests})
+	if err != nil {
+		return err
+	}
+
+	var d netlifyDeploy
+	u := fmt.Sprintf("%s/sites/%s/deploys", netlifyAPI, url.PathEscape(n.site))
+	if err := apiRequest(n.client, n.token, "POST", u, bytes.NewReader(body), "application/json", &d); err != nil {
+		return fmt.Errorf("creating deploy: %s", err)
+	}
+
+	// Upload only the files Netlify doesn't already have.
+	for _, digest := range d.Required {
+		p, ok := byDigest[digest]
+		if !ok {
+			return fmt.Errorf("netlify requested unknown file digest %s", digest)
+		}
+		u := fmt.Sprintf("%s/deploys/%s/files%s", netlifyAPI, d.ID, (&url.URL{Path: p}).EscapedPath())
+		if err := apiRequest(n.client, n.token, "PUT", u, bytes.NewReader(files[p]), "application/octet-stream", nil); err != nil {
+			return fmt.Errorf("uploading %s: %s", p, err)
+		}
+	}
+
+	return n.waitReady(d.ID)
+}
+
+// waitReady polls the deploy until it has been published.
+func (n netlify) waitReady(id string) error {
+	const timeout = 2 * time.Minute
+	u := fmt.Sprintf("%s/deploys/%s", netlifyAPI, id)
+
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(2 * time.Second) {
+		var d netlifyDeploy
+		if err := apiRequest(n.client, n.token, "GET", u, nil, "", &d); err != nil {
+			return fmt.Errorf("getting deploy %s: %s", id, err)
+		}
+		switch d.State {
+		case "ready":
+			return nil
+		case "error":
+			return fmt.Errorf("deploy %s failed: %s", id, d.ErrorMessage)
+		}
+	}
+	return fmt.Errorf("deploy %s not ready after %s", id, timeout)
+}
+
+// cloudflare deploys to a Cloudflare Pages project using the direct upload
+// API, the same sequence of requests made by "wrangler pages deploy".
+type cloudflare struct {
+	client  *http.Client
+	token   string
+	account string
+	project string
+}
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareResponse is the envelope of Cloudflare API responses.
+type cloudflareResponse struct {
+	Result interface{} `json:"result"`
+}
+
+type cloudflareAsset struct {
+	Key      string            `json:"key"`
+	Value    string            `json:"value"`
+	Metadata map[string]string `json:"metadata"`
+	Base64   bool              `json:"base64"`
+}
+
+// cloudflareConfigFiles are the files at the root of the site that
+// configure a Cloudflare Pages deployment, sent with the deployment
+// instead of being uploaded as assets to serve.
+var cloudflareConfigFiles = []string{headersName, redirectsName}
+
+func (c cloudflare) deploy(siteDir string) error {
+	files, err := siteFiles(siteDir)
+	if err != nil {
+		return fmt.Errorf("reading site files: %s", err)
+	}
+
+	// Assets are uploaded with a JWT scoped to the project, rather than
+	// the API token.
+	var upload struct {
+		JWT string `json:"jwt"`
+	}
+	u := fmt.Sprintf("%s/accounts/%s/pages/projects/%s/upload-token", cloudflareAPI, c.account, url.PathEscape(c.project))
+	if err := apiRequest(c.client, c.token, "GET", u, nil, "", &cloudflareResponse{&upload}); err != nil {
+		return fmt.Errorf("getting upload token: %s", err)
+	}
+
+	config := make(map[string][]byte)
+	for _, name := range cloudflareConfigFiles {
+		if b, ok := files["/"+name]; ok {
+			config[name] = b
+			delete(files, "/"+name)
+		}
+	}
+
+	manifest := make(map[string]string, len(files))
+	assets := make(map[string]cloudflareAsset, len(files))
+	var hashes []string
+	for p, b := range files {
+		encoded := base64.StdEncoding.EncodeToString(b)
+		// Asset keys are opaque content addresses: 32 hex characters
+		// derived from the contents and the file extension.
+		sum := sha256.Sum256([]byte(encoded + path.Ext(p)))
+		key := hex.EncodeToString(sum[:])[:32]
+		manifest[p] = key
+		if _, ok := assets[key]; !ok {
+			contentType := mime.TypeByExtension(path.Ext(p))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			assets[key] = cloudflareAsset{key, encoded, map[string]string{"contentType": contentType}, true}
+			hashes = append(hashes, key)
+		}
+	}
+
+	hashesBody, err := json.Marshal(map[string][]string{"hashes": hashes})
+	if err != nil {
+		return err
+	}
+
+	// Upload only the assets Cloudflare doesn't already have.
+	var missing []string
+	if err := apiRequest(c.client, upload.JWT, "POST", cloudflareAPI+"/pages/assets/check-missing", bytes.NewReader(hashesBody), "application/json", &cloudflareResponse{&missing}); err != nil {
+		return fmt.Errorf("checking missing assets: %s", err)
+	}
+	if len(missing) > 0 {
+		var payload []cloudflareAsset
+		for _, key := range missing {
+			a, ok := assets[key]
+			if !ok {
+				return fmt.Errorf("cloudflare requested unknown asset %s", key)
+			}
+			payload = append(payload, a)
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := apiRequest(c.client, upload.JWT, "POST", cloudflareAPI+"/pages/assets/upload", bytes.NewReader(body), "application/json", nil); err != nil {
+			return fmt.Errorf("uploading assets: %s", err)
+		}
+	}
+	if err := apiRequest(c.client, upload.JWT, "POST", cloudflareAPI+"/pages/assets/upsert-hashes", bytes.NewReader(hashesBody), "application/json", nil); err != nil {
+		return fmt.Errorf("upserting asset hashes: %s", err)
+	}
+
+	// Create the deployment from the manifest of uploaded assets.
+	form, contentType, err := cloudflareDeploymentForm(manifest, config)
+	if err != nil {
+		return err
+	}
+	u = fmt.Sprintf("%s/accounts/%s/pages/projects/%s/deployments", cloudflareAPI, c.account, url.PathEscape(c.project))
+	if err := apiRequest(c.client, c.token, "POST", u, form, contentType, nil); err != nil {
+		return fmt.Errorf("creating deployment: %s", err)
+	}
+	return nil
+}
+
+// cloudflareDeploymentForm returns the multipart body, and its content type,
+// of the request creating a deployment from the manifest of uploaded assets
+// and the configuration files, by name, such as _headers and _redirects.
+func cloudflareDeploymentForm(manifest map[string]string, config map[string][]byte) (*bytes.Buffer, string, error) {
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	if err := mw.WriteField("manifest", string(manifestJSON)); err != nil {
+		return nil, "", err
+	}
+	for _, name := range cloudflareConfigFiles {
+		b, ok := config[name]
+		if !ok {
+			continue
+		}
+		w, err := mw.CreateFormFile(name, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(b); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &form, mw.FormDataContentType(), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"testing"
+)
+
+func TestCloudflareDeploymentForm(t *testing.T) {
+	manifest := map[string]string{"/example.org/r/index.html": "0123456789abcdef0123456789abcdef"}
+	config := map[string][]byte{
+		headersName:   []byte("/*\n  Cache-Control: public, max-age=300\n"),
+		redirectsName: []byte("/r/*  /r/index.html  200\n"),
+	}
+	form, contentType, err := cloudflareDeploymentForm(manifest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	files := make(map[string]string)
+	mr := multipart.NewReader(form, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[p.FormName()] = string(b)
+		if p.FileName() != "" {
+			files[p.FormName()] = p.FileName()
+		}
+	}
+
+	want := map[string]string{
+		"manifest":    `{"/example.org/r/index.html":"0123456789abcdef0123456789abcdef"}`,
+		headersName:   string(config[headersName]),
+		redirectsName: string(config[redirectsName]),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("form fields = %q, want %q", got, want)
+	}
+	wantFiles := map[string]string{headersName: headersName, redirectsName: redirectsName}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("form files = %q, want %q", files, wantFiles)
+	}
+}
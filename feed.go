@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// siteFeedName is the name of the site-wide feed, at the root of the site.
+const siteFeedName = "feed.atom"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Link     atomLink     `xml:"link"`
+}
+
+// atomCategory holds the module path of an entry, which identifies the
+// entries to replace when the site-wide feed is regenerated.
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func releaseEntries(module string, rels []release) []atomEntry {
+	var entries []atomEntry
+	for _, r := range rels {
+		u := "https://pkg.go.dev/" + module + "@" + r.Version
+		entries = append(entries, atomEntry{
+			Title:    module + " " + r.Version,
+			ID:       u,
+			Updated:  r.Time.UTC().Format(time.RFC3339),
+			Category: atomCategory{module},
+			Link:     atomLink{Href: u},
+		})
+	}
+	return entries
+}
+
+// feedFile returns the feed with the entries, newest first, to be
+// served at path p.
+func feedFile(p, title, author string, entries []atomEntry) (File, error) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Updated > entries[j].Updated
+	})
+	updated := time.Now().UTC().Format(time.RFC3339)
+	if len(entries) > 0 {
+		updated = entries[0].Updated
+	}
+	u := "https://" + p
+	feed := atomFeed{
+		Title:   title,
+		ID:      u,
+		Updated: updated,
+		Author:  atomAuthor{author},
+		Links:   []atomLink{{Rel: "self", Href: u}},
+		Entries: entries,
+	}
+
+	f := File{path: p}
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return f, err
+	}
+	f.contents.WriteString(xml.Header)
+	f.contents.Write(b)
+	f.contents.WriteByte('\n')
+	return f, nil
+}
+
+// moduleFeedFile returns the feed of the module's releases.
+func moduleFeedFile(module string, rels []release) (File, error) {
+	p := path.Join(module, "@versions", "feed.atom")
+	return feedFile(p, module+" releases", module, releaseEntries(module, rels))
+}
+
+// siteFeedFile returns the site-wide feed of releases. The entries of
+// modules other than module are kept from the feed previously generated
+// at existing, if any.
+func siteFeedFile(s site, existing, module string, rels []release) (File, error) {
+	var entries []atomEntry
+	b, err := ioutil.ReadFile(existing)
+	switch {
+	case err == nil:
+		var old atomFeed
+		if err := xml.Unmarshal(b, &old); err != nil {
+			return File{}, err
+		}
+		for _, e := range old.Entries {
+			if e.Category.Term != module {
+				entries = append(entries, e)
+			}
+		}
+	case !os.IsNotExist(err):
+		return File{}, err
+	}
+	entries = append(entries, releaseEntries(module, rels)...)
+	return feedFile(path.Join(s.host, siteFeedName), s.host+" releases", s.host, entries)
+}
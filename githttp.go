@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v3/clients"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	githttp "gopkg.in/src-d/go-git.v3/clients/http"
+	"gopkg.in/src-d/go-git.v3/formats/pktline"
+)
+
+// gitToken is the access token given by -token or METAIMPORT_TOKEN, if
+// any.
+var gitToken string
+
+// gitAuthHost is the host of the repository given on the command line, the
+// only host the access token and the extra headers are sent to, so that
+// they don't leak to the hosts of submodules and mirrors.
+var gitAuthHost string
+
+// isAuthHost reports whether u is on the host of the repository.
+func isAuthHost(u *url.URL) bool {
+	return gitAuthHost != "" && strings.EqualFold(u.Hostname(), gitAuthHost)
+}
+
+// authScope returns the URL that git's http.<url>.* settings for the host
+// of the repository at u are scoped to.
+func authScope(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String()
+}
+
+// tokenUsers maps hosts to the user names their git servers expect along
+// with access tokens in basic auth.
+var tokenUsers = map[string]string{
+	"github.com":    "x-access-token",
+	"gitlab.com":    "oauth2",
+	"bitbucket.org": "x-token-auth",
+}
+
+// httpAuth returns the basic auth for fetching the repository at the https
+// URL, or nil if there are no credentials for it. The access token, if any,
+// is used for the host of the repository only, with the user name in the
+// URL, if any, or the user name the host expects. Otherwise, the credentials
+// for the host in the user's .netrc file, if any, are used, as the go
+// command does.
+func httpAuth(repoURL string) common.AuthMethod {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Scheme != "https" {
+		return nil
+	}
+	if gitToken == "" || !isAuthHost(u) {
+		if login, password, ok := netrcCredentials(u.Hostname()); ok {
+			return &basicAuth{login, password}
+		}
+		return nil
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = tokenUsers[u.Hostname()]
+	}
+	if user == "" {
+		user = "oauth2" // accepted by self-hosted GitLab and Gitea
+	}
+	return &basicAuth{user, gitToken}
+}
+
+// headerFlags is a flag.Value for the repeatable -git-header flag, whose
+// values have the form "Name: value". Environment variables in values are
+// expanded, so that secrets needn't appear on the command line.
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	var s []string
+	for name := range h {
+		s = append(s, name)
+	}
+	return strings.Join(s, ",")
+}
+
+func (h headerFlags) Set(v string) error {
+	i := strings.Index(v, ":")
+	if i <= 0 {
+		return fmt.Errorf("want Name: value, got %q", v)
+	}
+	name := textproto.TrimString(v[:i])
+	value := os.ExpandEnv(textproto.TrimString(v[i+1:]))
+	http.Header(h).Add(name, value)
+	return nil
+}
+
+// headerTransport adds headers to the requests it sends to the host of the
+// repository, including after redirects within it.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isAuthHost(req.URL) {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// basicAuth is the basic auth sent with smart-HTTP git requests.
+type basicAuth struct {
+	user, password string
+}
+
+func (a *basicAuth) Name() string { return "http-basic-auth" }
+
+func (a *basicAuth) String() string { return a.Name() + " - " + a.user + ":*******" }
+
+// httpUploadPack is a smart-HTTP upload-pack service. Unlike go-git's, it
+// can ask for a shallow pack.
+type httpUploadPack struct {
+	client   *http.Client
+	endpoint common.Endpoint
+	auth     *basicAuth
+	caps     *common.Capabilities // advertised by the remote
+}
+
+func (s *httpUploadPack) Connect(ep common.Endpoint) error {
+	s.endpoint = ep
+	return nil
+}
+
+func (s *httpUploadPack) ConnectWithAuth(ep common.Endpoint, auth common.AuthMethod) error {
+	a, ok := auth.(*basicAuth)
+	if !ok {
+		return githttp.InvalidAuthMethodErr
+	}
+	s.endpoint, s.auth = ep, a
+	return nil
+}
+
+func (s *httpUploadPack) Info() (*common.GitUploadPackInfo, error) {
+	res, err := s.do("GET", "/info/refs?service="+common.GitUploadPackServiceName, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	i := common.NewGitUploadPackInfo()
+	s.caps = i.Capabilities
+	return i, i.Decode(pktline.NewDecoder(res.Body))
+}
+
+func (s *httpUploadPack) Fetch(r *common.GitUploadPackRequest) (io.ReadCloser, error) {
+	res, err := s.do("POST", "/"+common.GitUploadPackServiceName, encodeUploadPackRequest(r, s.caps))
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(res.Body)
+	if err := readUploadPackResponse(br, r); err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("reading response: %s", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, res.Body}, nil
+}
+
+func (s *httpUploadPack) do(method, path string, body *strings.Reader) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = body
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, method, string(s.endpoint)+path, r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "git/1.0")
+	if body != nil {
+		req.Header.Set("Accept", "application/x-git-upload-pack-result")
+		req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	}
+	if s.auth != nil {
+		req.SetBasicAuth(s.auth.user, s.auth.password)
+	}
+	w := newWatchdog(cancel)
+	res, err := s.client.Do(req)
+	if err != nil {
+		w.stop()
+		cancel()
+		return nil, w.check(err)
+	}
+	if err := githttp.NewHTTPError(res); err != nil {
+		w.stop()
+		res.Body.Close()
+		cancel()
+		return nil, err
+	}
+	resBody := res.Body
+	res.Body = w.readCloser(struct {
+		io.Reader
+		io.Closer
+	}{resBody, closerFunc(func() error {
+		defer cancel()
+		return resBody.Close()
+	})})
+	return res, nil
+}
+
+// gitHTTPClient is the HTTP client for smart-HTTP git fetches.
+var gitHTTPClient = http.DefaultClient
+
+// gitProxy is the proxy given by -proxy, if any.
+var gitProxy *url.URL
+
+// gitCACert is the file of the CA certificates given by -ca-cert, if any,
+// and gitInsecure is set by -insecure-skip-verify. They are for git servers
+// with certificates not signed by a CA known to the system.
+var (
+	gitCACert   string
+	gitInsecure bool
+)
+
+// configureGitHTTP sets the HTTP client for smart-HTTP git fetches to send
+// the extra headers, if any, with each request to the host of the
+// repository, to use the proxy, if any, instead of the one given by the
+// environment, and to verify certificates as -ca-cert and
+// -insecure-skip-verify say.
+func configureGitHTTP(header http.Header, proxy *url.URL) error {
+	gitHTTPHeader, gitProxy = header, proxy
+	if len(header) == 0 && proxy == nil && gitCACert == "" && !gitInsecure {
+		return nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if gitCACert != "" || gitInsecure {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: gitInsecure}
+	}
+	if gitCACert != "" {
+		pem, err := ioutil.ReadFile(gitCACert)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", gitCACert)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if gitInsecure {
+		warnf("not verifying the TLS certificates of git servers")
+	}
+	var rt http.RoundTripper = t
+	if len(header) > 0 {
+		rt = headerTransport{rt, header}
+	}
+	gitHTTPClient = &http.Client{Transport: rt}
+	return nil
+}
+
+// installHTTPProtocol makes go-git fetch URLs with the scheme, http or
+// https, with a new smart-HTTP upload-pack service. Each repository needs
+// its own, since the service holds the URL it is connected to.
+func installHTTPProtocol(scheme string) {
+	clients.InstallProtocol(scheme, &httpUploadPack{client: gitHTTPClient})
+}
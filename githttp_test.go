@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withAuth sets the access token and the host of the repository for the
+// duration of the test, with an empty .netrc file.
+func withAuth(t *testing.T, token, host string) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+	oldToken, oldHost := gitToken, gitAuthHost
+	gitToken, gitAuthHost = token, host
+	t.Cleanup(func() { gitToken, gitAuthHost = oldToken, oldHost })
+}
+
+func TestTokenScopedToRepositoryHost(t *testing.T) {
+	withAuth(t, "secret", "github.com")
+
+	a, ok := httpAuth("https://github.com/user/repo").(*basicAuth)
+	if !ok || a.user != "x-access-token" || a.password != "secret" {
+		t.Errorf("httpAuth for the repository = %v, want the token", a)
+	}
+	// A submodule, or mirror, on another host.
+	if a := httpAuth("https://git.example.com/other/sub.git"); a != nil {
+		t.Errorf("httpAuth for a submodule on another host = %v, want none", a)
+	}
+
+	for _, kv := range gitEnv("https://git.example.com/other/sub.git") {
+		if strings.Contains(kv, "Authorization") {
+			t.Errorf("git environment for a submodule on another host has %q", kv)
+		}
+	}
+	var found bool
+	for _, kv := range gitEnv("https://github.com/user/repo") {
+		if strings.HasSuffix(kv, "=http.https://github.com/.extraHeader") {
+			found = true
+		}
+		if strings.HasSuffix(kv, "=http.extraHeader") {
+			t.Errorf("git environment for the repository has the unscoped %q", kv)
+		}
+	}
+	if !found {
+		t.Errorf("git environment for the repository has no credentials scoped to https://github.com/")
+	}
+}
+
+func TestHeadersScopedToRepositoryHost(t *testing.T) {
+	withAuth(t, "", "github.com")
+	old := gitHTTPHeader
+	gitHTTPHeader = http.Header{"X-Secret": {"s3cr3t"}}
+	t.Cleanup(func() { gitHTTPHeader = old })
+
+	var got []string
+	rt := headerTransport{roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		got = append(got, req.URL.Host+" "+req.Header.Get("X-Secret"))
+		return nil, errors.New("not sent")
+	}), gitHTTPHeader}
+	for _, u := range []string{"https://github.com/user/repo/info/refs", "https://git.example.com/other/sub.git/info/refs"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		rt.RoundTrip(req)
+	}
+	want := []string{"github.com s3cr3t", "git.example.com "}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("headers sent = %q, want %q", got, want)
+	}
+
+	for _, kv := range gitEnv("https://git.example.com/other/sub.git") {
+		if strings.Contains(kv, "s3cr3t") {
+			t.Errorf("git environment for a submodule on another host has %q", kv)
+		}
+	}
+	env := strings.Join(gitEnv("https://github.com/user/repo"), "\n")
+	if !strings.Contains(env, "=http.https://github.com/.extraHeader\n") || !strings.Contains(env, "=X-Secret: s3cr3t") {
+		t.Errorf("git environment for the repository has no header scoped to https://github.com/:\n%s", env)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
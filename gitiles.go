@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// gitilesURL returns the URL at which Gitiles shows the repository at
+// repoURL, which is the same but for the /a/ prefix of authenticated
+// fetches and the .git suffix.
+func gitilesURL(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return strings.TrimSuffix(repoURL, ".git")
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(gerritProject(u.Path), ".git")
+	if u.Path != "" {
+		u.Path = "/" + u.Path
+	}
+	return u.String()
+}
+
+// gerritGitilesURL returns the URL at which the Gitiles plugin of the
+// Gerrit server at u shows the repository.
+func gerritGitilesURL(u *url.URL) string {
+	g := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/plugins/gitiles/" + strings.TrimSuffix(gerritProject(u.Path), ".git"),
+	}
+	return g.String()
+}
+
+// gerritProject returns the name of the project at the path of its URL,
+// without the /a/ prefix that Gerrit and Gitiles use for authenticated
+// fetches.
+func gerritProject(p string) string {
+	p = strings.Trim(p, "/")
+	if strings.HasPrefix(p, "a/") {
+		p = p[len("a/"):]
+	}
+	return p
+}
@@ -0,0 +1,13 @@
+package main
+
+import "net/url"
+
+// gitwebURL returns the URL of gitweb on the host of the repository at u,
+// over https unless the repository is fetched over http.
+func gitwebURL(u *url.URL) string {
+	g := url.URL{Scheme: "https", Host: webHost(u), Path: "/gitweb/"}
+	if u.Scheme == "http" {
+		g.Scheme = "http"
+	}
+	return g.String()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGitwebURL(t *testing.T) {
+	tests := []struct {
+		repoURL, want string
+	}{
+		{"https://git.example.com/project.git", "https://git.example.com/gitweb/"},
+		{"http://git.example.com:8080/project.git", "http://git.example.com:8080/gitweb/"},
+		{"ssh://git@git.example.com:2222/project.git", "https://git.example.com/gitweb/"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.repoURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gitwebURL(u); got != tt.want {
+			t.Errorf("gitwebURL(%q) = %q, want %q", tt.repoURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path"
+)
+
+// headersName is the name of the headers file, at the root of the site.
+const headersName = "_headers"
+
+// headersFile returns a _headers file, in the format read by Netlify and
+// Cloudflare Pages, setting the response headers for the site. See
+// https://docs.netlify.com/routing/headers/. The rules apply to the whole
+// site rather than the import prefix, so that sites generated from several
+// repositories can share the file.
+func headersFile(s site) File {
+	f := File{path: path.Join(s.host, headersName)}
+	// The short max-age keeps caches from serving stale tags for long after
+	// the site is regenerated. The content security policy allows the
+	// resources of the site itself, such as the stylesheets, images and
+	// fonts of a -template or -head-include, inline styles, and inline
+	// scripts, such as that of -redirect-js, but nothing from elsewhere.
+	f.contents.WriteString(`/*
+  Cache-Control: public, max-age=300
+  X-Content-Type-Options: nosniff
+  Referrer-Policy: no-referrer
+  Content-Security-Policy: default-src 'self'; style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline'; frame-ancestors 'none'
+/*.atom
+  Content-Type: application/atom+xml; charset=utf-8
+/` + apiIndexName + `
+  Content-Type: application/json; charset=utf-8
+`)
+	return f
+}
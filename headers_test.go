@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// contentSecurityPolicy returns the directives of the Content-Security-Policy
+// in the _headers file, by name.
+func contentSecurityPolicy(t *testing.T, headers string) map[string][]string {
+	for _, line := range strings.Split(headers, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Content-Security-Policy:") {
+			continue
+		}
+		directives := make(map[string][]string)
+		for _, d := range strings.Split(strings.TrimPrefix(line, "Content-Security-Policy:"), ";") {
+			if f := strings.Fields(d); len(f) > 0 {
+				directives[f[0]] = f[1:]
+			}
+		}
+		return directives
+	}
+	t.Fatal("no Content-Security-Policy in _headers")
+	return nil
+}
+
+// allows reports whether the policy allows a resource of the kind, such as
+// style-src, from source, such as 'self' or 'unsafe-inline'.
+func allows(policy map[string][]string, kind, source string) bool {
+	sources, ok := policy[kind]
+	if !ok {
+		sources = policy["default-src"]
+	}
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHeadersAllowTemplatedPages(t *testing.T) {
+	s := newSite("example.org/r")
+	f := headersFile(s)
+	policy := contentSecurityPolicy(t, f.contents.String())
+
+	// A page of a custom template with the branding of the site.
+	const text = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta name="go-import" content="{{ .GoImport.ImportPrefix }} {{ .GoImport.VCS }} {{ .GoImport.RepoRoot }}">
+		<link rel="stylesheet" href="/style.css">
+		<style>body { margin: 0 }</style>
+	</head>
+	<body>
+		<img src="/logo.png" style="width: 4em">
+	</body>
+</html>
+`
+	var page bytes.Buffer
+	tmpl := template.Must(template.New("").Parse(text))
+	if err := tmpl.Execute(&page, TemplateArgs{GoImport: GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "https://github.com/user/r"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resources := []struct {
+		re           *regexp.Regexp
+		kind, source string
+	}{
+		{regexp.MustCompile(`<link rel="stylesheet" href="/`), "style-src", "'self'"},
+		{regexp.MustCompile(`<style>`), "style-src", "'unsafe-inline'"},
+		{regexp.MustCompile(`style="`), "style-src", "'unsafe-inline'"},
+		{regexp.MustCompile(`<img src="/`), "img-src", "'self'"},
+	}
+	for _, r := range resources {
+		if !r.re.Match(page.Bytes()) {
+			t.Fatalf("page has no resource matching %s", r.re)
+		}
+		if !allows(policy, r.kind, r.source) {
+			t.Errorf("%s %s of the page is blocked by the content security policy %q", r.kind, r.source, policy)
+		}
+	}
+
+	// The inline script of -redirect-js.
+	if !allows(policy, "script-src", "'unsafe-inline'") {
+		t.Errorf("inline scripts are blocked by the content security policy %q", policy)
+	}
+	// Nothing is loaded from elsewhere.
+	if allows(policy, "script-src", "*") || allows(policy, "default-src", "*") {
+		t.Errorf("the content security policy %q allows any origin", policy)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// expandLaunchpadURL expands Launchpad's lp: shorthand, as in lp:project or
+// lp:~user/project/branch, which bzr understands but git and go get don't,
+// to the https URL of the repository, and reports whether repoURL is one.
+// Git repositories are served from git.launchpad.net, and Bazaar branches
+// from launchpad.net, at which the go command fetches them.
+func expandLaunchpadURL(repoURL, vcs string) (string, bool) {
+	if !strings.HasPrefix(repoURL, "lp:") {
+		return "", false
+	}
+	u := url.URL{Scheme: "https", Path: "/" + strings.TrimPrefix(repoURL[len("lp:"):], "/")}
+	switch vcs {
+	case "git":
+		u.Host = "git.launchpad.net"
+	case "bzr":
+		u.Host = "launchpad.net"
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+// launchpadBranch returns the path of the Bazaar branch at repoURL, as in
+// ~user/project/branch or project for the development focus of the
+// project, and reports whether repoURL is a URL of one on Launchpad.
+func launchpadBranch(repoURL string) (string, bool) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", false
+	}
+	p := strings.Trim(u.Path, "/")
+	switch u.Host {
+	case "launchpad.net", "code.launchpad.net":
+	case "bazaar.launchpad.net":
+		p = strings.TrimPrefix(p, "+branch/")
+	default:
+		return "", false
+	}
+	if p == "" {
+		return "", false
+	}
+	return p, true
+}
+
+// loggerheadURL returns the URL at which Loggerhead, Launchpad's browser of
+// Bazaar branches, shows the branch at the path.
+func loggerheadURL(branch string) string {
+	if !strings.HasPrefix(branch, "~") {
+		branch = "+branch/" + branch
+	}
+	u := url.URL{Scheme: "https", Host: "bazaar.launchpad.net", Path: "/" + branch}
+	return u.String()
+}
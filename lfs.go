@@ -0,0 +1,16 @@
+package main
+
+import "strings"
+
+// lfsPointerPrefix starts the pointer files that Git LFS stores in the
+// repository in place of the contents of the files it tracks. See
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md.
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1\n"
+
+// isLFSPointer reports whether the contents of a file are a Git LFS
+// pointer. Such files are treated as ordinary ones: the contents they
+// point to are never fetched, and the go command doesn't fetch them for
+// module zips either.
+func isLFSPointer(contents string) bool {
+	return len(contents) < 1024 && strings.HasPrefix(contents, lfsPointerPrefix)
+}
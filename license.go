@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// licenseFileRe matches the names of files pkg.go.dev looks at for
+// licenses.
+var licenseFileRe = regexp.MustCompile(`(?i)^(licen[cs]e|copying|unlicense)([-.].*)?$`)
+
+// redistributablePhrases identify the common redistributable licenses
+// accepted by pkg.go.dev. See https://pkg.go.dev/license-policy.
+var redistributablePhrases = []string{
+	"apache license",
+	"boost software license",
+	"creative commons legal code", // CC0
+	"gnu affero general public license",
+	"gnu general public license",
+	"gnu lesser general public license",
+	"isc license",
+	"mozilla public license",
+	"permission is hereby granted, free of charge", // MIT
+	"permission to use, copy, modify, and/or distribute this software",       // ISC, 0BSD
+	"redistribution and use in source and binary forms",                      // BSD
+	"this is free and unencumbered software released into the public domain", // Unlicense
+	"zlib license",
+}
+
+// checkLicense returns an error if neither the module directory dir nor any
+// of its parent directories in the repository has a license file
+// recognized as redistributable.
+func checkLicense(tree sourceTree, dir string) error {
+	var unrecognized []string
+	for d := dir; ; d = path.Dir(d) {
+		names, err := tree.dirEntries(d)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !licenseFileRe.MatchString(name) {
+				continue
+			}
+			p := path.Join(d, name)
+			f, err := tree.file(p)
+			if err == errFileNotFound {
+				continue // a directory
+			}
+			if err != nil {
+				return err
+			}
+			contents, err := f.contents()
+			if err != nil {
+				return fmt.Errorf("reading %s: %s", p, err)
+			}
+			if isRedistributable(contents) {
+				return nil
+			}
+			if isLFSPointer(contents) {
+				p += " (a Git LFS pointer)"
+			}
+			unrecognized = append(unrecognized, p)
+		}
+		if d == "." {
+			break
+		}
+	}
+
+	where := "in the repository root"
+	if dir != "." {
+		where = fmt.Sprintf("for the module in %s", dir)
+	}
+	if len(unrecognized) > 0 {
+		return fmt.Errorf("no redistributable license recognized %s (unrecognized: %s); pkg.go.dev won't display its documentation", where, strings.Join(unrecognized, ", "))
+	}
+	return fmt.Errorf("no license file %s; pkg.go.dev won't display its documentation", where)
+}
+
+func isRedistributable(license string) bool {
+	license = strings.ToLower(strings.Join(strings.Fields(license), " "))
+	for _, phrase := range redistributablePhrases {
+		if strings.Contains(license, phrase) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"text/template/parse"
+	"time"
+)
+
+const lintTemplateHelp = `usage: metaimport lint-template <file>
+
+lint-template checks a custom page template, as given by -template. It
+reports parse errors, references to fields that TemplateArgs doesn't have,
+and errors executing the template with sample data, and checks that each
+sample page has a well-formed go-import tag that go get would use. The exit
+status is 1 if any problem is found.
+
+The template is executed with a TemplateArgs value:
+
+	type TemplateArgs struct {
+		GoImport      GoImport
+		GoSource      *GoSource // nil without -godoc
+		GodocRedirect bool
+		RedirectJS    bool
+		GodocURL      string    // empty with -docsite none
+		ImportPath    string    // of the package the page is for
+		Dir           string    // of the package, relative to the repository root
+		Branch        string    // empty for a tag or revision
+		Revision      string
+		Generated     time.Time // in UTC
+		Packages      []PackageLink // with -index, on the root page only
+		Synopsis      string        // empty without -synopsis
+		CanonicalURL  string        // empty without -canonical
+		HeadInclude   template.HTML // empty without -head-include
+	}
+
+	type GoImport struct {
+		ImportPrefix, VCS, RepoRoot string
+		Subdir                      string // may be empty
+	}
+
+	type GoSource struct {
+		Prefix, Home, Directory, File string
+	}
+
+	type PackageLink struct {
+		ImportPath        string
+		DocURL, SourceURL string // may be empty
+		Synopsis          string // may be empty
+	}
+`
+
+// lintSamples are the template arguments that templates are executed with
+// by lint-template, covering the optional parts of TemplateArgs.
+var lintSamples = []TemplateArgs{
+	{
+		GoImport:      GoImport{ImportPrefix: "example.org/myrepo", VCS: "git", RepoRoot: "https://github.com/user/myrepo"},
+		GodocRedirect: true,
+		GodocURL:      "https://pkg.go.dev/example.org/myrepo/pkg",
+		ImportPath:    "example.org/myrepo/pkg",
+		Dir:           "pkg",
+		Synopsis:      "Package pkg does things.",
+		CanonicalURL:  "https://example.org/myrepo/pkg",
+		HeadInclude:   `<meta name="google-site-verification" content="0123456789">`,
+		Branch:        "master",
+		Revision:      "0123456789abcdef0123456789abcdef01234567",
+		Generated:     time.Date(2017, 11, 5, 12, 0, 0, 0, time.UTC),
+		GoSource: &GoSource{
+			Prefix:    "example.org/myrepo",
+			Home:      "_",
+			Directory: "https://github.com/user/myrepo/tree/master{/dir}",
+			File:      "https://github.com/user/myrepo/blob/master{/dir}/{file}#L{line}",
+		},
+	},
+	{
+		GoImport:      GoImport{ImportPrefix: "example.org/myrepo", VCS: "git", RepoRoot: "https://github.com/user/myrepo"},
+		GodocRedirect: true,
+		RedirectJS:    true,
+		GodocURL:      "https://pkg.go.dev/example.org/myrepo/pkg",
+		ImportPath:    "example.org/myrepo/pkg",
+		Dir:           "pkg",
+		Revision:      "0123456789abcdef0123456789abcdef01234567",
+	},
+	{
+		GoImport:   GoImport{ImportPrefix: "example.org/myrepo", VCS: "git", RepoRoot: "https://github.com/user/myrepo"},
+		GodocURL:   "https://pkg.go.dev/example.org/myrepo",
+		ImportPath: "example.org/myrepo",
+		Dir:        ".",
+		Packages: []PackageLink{
+			{ImportPath: "example.org/myrepo/pkg", DocURL: "https://pkg.go.dev/example.org/myrepo/pkg", SourceURL: "https://github.com/user/myrepo/tree/master/pkg", Synopsis: "Package pkg does things."},
+			{ImportPath: "example.org/myrepo/other", DocURL: "https://pkg.go.dev/example.org/myrepo/other"},
+		},
+	},
+	{
+		GoImport:   GoImport{ImportPrefix: "example.org/mod", VCS: "git", RepoRoot: "https://github.com/user/myrepo", Subdir: "mod"},
+		ImportPath: "example.org/mod/pkg",
+		Dir:        "mod/pkg",
+	},
+}
+
+func runLintTemplate(args []string) {
+	fs := flag.NewFlagSet("lint-template", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, lintTemplateHelp)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+	name := fs.Arg(0)
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	problems := lintTemplate(filepath.Base(name), string(b))
+	for _, p := range problems {
+		fmt.Printf("%s\n", p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// lintTemplate returns the problems found in the template text.
+func lintTemplate(name, text string) []error {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return []error{err}
+	}
+
+	root := reflect.TypeOf(TemplateArgs{})
+	c := fieldChecker{tmpl: t, tree: t.Tree, root: root, seen: make(map[calledTemplate]bool)}
+	c.walk(t.Tree.Root, root)
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+
+	var problems []error
+	for i, args := range lintSamples {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, args); err != nil {
+			problems = append(problems, fmt.Errorf("sample %d: %s", i+1, err))
+			continue
+		}
+		importPath := args.GoImport.ImportPrefix + "/pkg"
+		if err := validatePage(importPath, buf.Bytes(), args.GoImport); err != nil {
+			problems = append(problems, fmt.Errorf("sample %d: %s", i+1, err))
+		}
+	}
+	return problems
+}
+
+// fieldChecker reports references to fields that don't exist, by walking
+// a template's parse tree while tracking the type of dot. Where the type
+// can't be determined statically, such as in the result of a function,
+// references aren't checked. The templates defined with {{define}} and
+// {{block}} are walked where they are called, with the type of the value
+// passed to them.
+type fieldChecker struct {
+	tmpl *template.Template // for looking up the defined templates
+	tree *parse.Tree
+	root reflect.Type // the type of $
+	seen map[calledTemplate]bool
+	errs []error
+}
+
+// calledTemplate is a defined template called with a value of the type.
+type calledTemplate struct {
+	name string
+	typ  reflect.Type
+}
+
+func (c *fieldChecker) walk(n parse.Node, dot reflect.Type) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, n := range n.Nodes {
+			c.walk(n, dot)
+		}
+	case *parse.ActionNode:
+		c.pipe(n.Pipe, dot)
+	case *parse.TemplateNode:
+		c.pipe(n.Pipe, dot)
+		c.call(n, dot)
+	case *parse.IfNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, dot)
+		c.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		c.pipe(n.Pipe, dot)
+		c.walk(n.List, c.pipeType(n.Pipe, dot))
+		c.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		c.pipe(n.Pipe, dot)
+		var elem reflect.Type
+		if t := indirect(c.pipeType(n.Pipe, dot)); t != nil {
+			switch t.Kind() {
+			case reflect.Array, reflect.Slice, reflect.Map:
+				elem = t.Elem()
+			}
+		}
+		c.walk(n.List, elem)
+		c.walk(n.ElseList, dot)
+	}
+}
+
+// call walks the defined template called by n, in which both dot and $ are
+// the value passed to it. Each template is walked once per type, which also
+// stops recursive templates.
+func (c *fieldChecker) call(n *parse.TemplateNode, dot reflect.Type) {
+	if n.Pipe == nil {
+		return // dot is nil
+	}
+	arg := c.pipeType(n.Pipe, dot)
+	t := c.tmpl.Lookup(n.Name)
+	if arg == nil || t == nil || t.Tree == nil {
+		return
+	}
+	key := calledTemplate{n.Name, arg}
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	sub := fieldChecker{tmpl: c.tmpl, tree: t.Tree, root: arg, seen: c.seen}
+	sub.walk(t.Tree.Root, arg)
+	c.errs = append(c.errs, sub.errs...)
+}
+
+func (c *fieldChecker) pipe(p *parse.PipeNode, dot reflect.Type) {
+	if p == nil {
+		return
+	}
+	for _, cmd := range p.Cmds {
+		for _, arg := range cmd.Args {
+			c.arg(arg, dot)
+		}
+	}
+}
+
+func (c *fieldChecker) arg(n parse.Node, dot reflect.Type) {
+	switch n := n.(type) {
+	case *parse.FieldNode:
+		c.resolve(n, dot, n.Ident)
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			c.resolve(n, c.root, n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		if p, ok := n.Node.(*parse.PipeNode); ok {
+			c.pipe(p, dot)
+		}
+	case *parse.PipeNode:
+		c.pipe(n, dot)
+	}
+}
+
+// pipeType returns the type of the pipeline's value, or nil if unknown.
+func (c *fieldChecker) pipeType(p *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if len(p.Decl) > 0 || len(p.Cmds) != 1 || len(p.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch n := p.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return c.resolve(n, dot, n.Ident)
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			return c.resolve(n, c.root, n.Ident[1:])
+		}
+	}
+	return nil
+}
+
+// resolve returns the type of the chain of fields in t, reporting an error
+// for any field that doesn't exist. It returns nil if the type is unknown.
+func (c *fieldChecker) resolve(n parse.Node, t reflect.Type, fields []string) reflect.Type {
+	for _, name := range fields {
+		if t == nil {
+			return nil
+		}
+		if _, ok := t.MethodByName(name); ok {
+			return nil
+		}
+		s := indirect(t)
+		switch s.Kind() {
+		case reflect.Struct:
+			f, ok := s.FieldByName(name)
+			if !ok || f.PkgPath != "" {
+				loc, _ := c.tree.ErrorContext(n)
+				c.errs = append(c.errs, fmt.Errorf("%s: can't evaluate field %s in type %s", loc, name, s))
+				return nil
+			}
+			t = f.Type
+		case reflect.Map:
+			t = s.Elem()
+		default:
+			return nil
+		}
+	}
+	return t
+}
+
+// indirect returns the type pointed to by t, if t is a pointer type.
+func indirect(t reflect.Type) reflect.Type {
+	if t != nil && t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
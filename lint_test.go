@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintTemplateDefinedTemplates(t *testing.T) {
+	text := `{{ template "imp" .GoImport }}{{ block "x" . }}{{ .Nope }}{{ end }}
+{{ define "imp" }}{{ .ImportPrefix }} {{ .RepoRot }}{{ template "imp" . }}{{ end }}`
+	var got []string
+	for _, err := range lintTemplate("t.html", text) {
+		got = append(got, err.Error())
+	}
+	for _, want := range []string{"field RepoRot in type main.GoImport", "field Nope in type main.TemplateArgs"} {
+		if !strings.Contains(strings.Join(got, "\n"), want) {
+			t.Errorf("lintTemplate problems %q don't mention %q", got, want)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("lintTemplate found %d problems, want 2: %q", len(got), got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v3/clients"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	"gopkg.in/src-d/go-git.v3/formats/pktline"
+)
+
+// noFetch is set by -no-fetch. Only repositories on disk are read then,
+// and nothing is fetched over the network.
+var noFetch bool
+
+// localRepoPath returns the path of the repository if repoURL is a file URL
+// or the path of a directory, and reports whether it is. A directory in a
+// checkout, or in a worktree added with git worktree, stands for the
+// checkout.
+func localRepoPath(repoURL string) (string, bool) {
+	u, err := url.Parse(repoURL)
+	if err == nil && u.Scheme == "file" {
+		return topLevel(filepath.FromSlash(u.Path)), true
+	}
+	if err == nil && len(u.Scheme) > 1 {
+		return "", false // a URL; a single letter is a Windows drive
+	}
+	if fi, err := os.Stat(repoURL); err == nil && fi.IsDir() {
+		return topLevel(repoURL), true
+	}
+	return "", false
+}
+
+// topLevel returns the root of the checkout that dir is in, or dir itself
+// if it isn't in one, such as a bare repository.
+func topLevel(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return dir
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+// originURL returns the URL of the origin remote of the local repository,
+// which is advertised instead of its path.
+func originURL(dir string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "config", "--get", "remote.origin.url").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s has no origin remote to advertise; give the URL to advertise with -public-url", dir)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// advertisedOriginURL returns the URL to advertise for the origin remote of
+// the local repository in dir. SCP-like addresses, which go get doesn't
+// accept, are converted to https, and origins go get can't fetch from,
+// such as local paths, are rejected.
+func advertisedOriginURL(dir, origin string) (string, error) {
+	// As in git, an address is SCP-like if a colon comes before any slash,
+	// and the user name is optional. A single letter is a Windows drive.
+	if i := strings.Index(origin, ":"); i > 1 && !strings.Contains(origin[:i], "/") && !strings.HasPrefix(origin[i:], "://") {
+		host := origin[strings.LastIndex(origin[:i], "@")+1 : i]
+		u := url.URL{Scheme: "https", Host: host, Path: "/" + strings.TrimPrefix(origin[i+1:], "/")}
+		return u.String(), nil
+	}
+	if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Scheme == "file" || u.Host == "" {
+		return "", fmt.Errorf("the origin remote of %s, %s, isn't a URL go get can fetch from; give the URL to advertise with -public-url", dir, origin)
+	}
+	return origin, nil
+}
+
+// installLocalProtocol makes go-git fetch file URLs from the repository in
+// dir by running git upload-pack, as git itself does, and returns the file
+// URL to use for the repository.
+func installLocalProtocol(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", fmt.Errorf("git command not found; it is needed to read local repositories")
+	}
+	clients.InstallProtocol("file", &localUploadPack{dir: abs})
+	return fileURL(abs)
+}
+
+// fileURL returns the file URL of the directory.
+func fileURL(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String(), nil
+}
+
+// localUploadPack is a go-git upload pack service for a local repository,
+// speaking the stateless protocol that smart HTTP uses with git
+// upload-pack.
+type localUploadPack struct {
+	dir  string
+	caps *common.Capabilities // advertised by upload-pack
+}
+
+func (s *localUploadPack) Connect(common.Endpoint) error { return nil }
+
+func (s *localUploadPack) ConnectWithAuth(common.Endpoint, common.AuthMethod) error { return nil }
+
+func (s *localUploadPack) Info() (*common.GitUploadPackInfo, error) {
+	cmd := exec.Command("git", "upload-pack", "--stateless-rpc", "--advertise-refs", s.dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git upload-pack %s: %s: %s", s.dir, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	i := common.NewGitUploadPackInfo()
+	s.caps = i.Capabilities
+	return i, i.Decode(pktline.NewDecoder(bytes.NewReader(out)))
+}
+
+func (s *localUploadPack) Fetch(r *common.GitUploadPackRequest) (io.ReadCloser, error) {
+	cmd := exec.Command("git", "upload-pack", "--stateless-rpc", s.dir)
+	cmd.Stdin = encodeUploadPackRequest(r, s.caps)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(stdout)
+	if err := readUploadPackResponse(br, r); err != nil {
+		cmd.Wait()
+		return nil, fmt.Errorf("git upload-pack %s: %s", s.dir, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return cmdReadCloser{br, cmd}, nil
+}
+
+// cmdReadCloser reads the output of a command, and waits for the command
+// on Close.
+type cmdReadCloser struct {
+	io.Reader
+	cmd *exec.Cmd
+}
+
+func (c cmdReadCloser) Close() error {
+	io.Copy(ioutil.Discard, c.Reader)
+	return c.cmd.Wait()
+}
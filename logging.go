@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// A logLevel is the minimum severity of the messages logged. Errors are
+// always logged, using fatalf.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var logLevels = map[string]logLevel{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// level is the current log level, set by the -log-level and -quiet flags.
+var level = levelInfo
+
+func setLogLevel(name string, quiet bool) error {
+	l, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("invalid -log-level value %q", name)
+	}
+	level = l
+	if quiet {
+		level = levelError
+	}
+	return nil
+}
+
+// exitFuncs are run by fatalf before it exits, in reverse order.
+var exitFuncs []func()
+
+// atExit registers f to run before the program exits on an error, such as
+// to remove temporary files, since log.Fatalf skips deferred calls.
+func atExit(f func()) {
+	exitFuncs = append(exitFuncs, f)
+}
+
+// fatalf runs the functions registered with atExit, and then logs the error
+// and exits, as log.Fatalf does.
+func fatalf(format string, args ...interface{}) {
+	for i := len(exitFuncs) - 1; i >= 0; i-- {
+		exitFuncs[i]()
+	}
+	log.Fatalf(format, args...)
+}
+
+func warnf(format string, args ...interface{}) {
+	if level >= levelWarn {
+		log.Printf("warning: "+format, args...)
+	}
+}
+
+func infof(format string, args ...interface{}) {
+	if level >= levelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+func debugf(format string, args ...interface{}) {
+	if level >= levelDebug {
+		log.Printf("debug: "+format, args...)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestName is the path of the manifest written with -manifest,
+// relative to the output directory, outside the site so that it isn't
+// deployed.
+const manifestName = "manifest.json"
+
+// govanityName is the path of the configuration of govanityurls written
+// with -manifest govanityurls, relative to the output directory.
+const govanityName = "vanity.yaml"
+
+type manifest struct {
+	Generated time.Time      `json:"generated"`
+	Revision  string         `json:"revision"`
+	Pages     []manifestPage `json:"pages"`
+}
+
+type manifestPage struct {
+	ImportPath   string          `json:"importPath"`
+	ImportPrefix string          `json:"importPrefix"`
+	VCS          string          `json:"vcs"`
+	RepoRoot     string          `json:"repoRoot"`
+	Subdir       string          `json:"subdir,omitempty"`
+	File         string          `json:"file"` // relative to the output directory
+	GoSource     *manifestSource `json:"goSource,omitempty"`
+}
+
+type manifestSource struct {
+	Home      string `json:"home"`
+	Directory string `json:"directory"`
+	File      string `json:"file"`
+}
+
+// manifestFile returns the manifest listing the package pages among files,
+// sorted by import path, for tools that act on the generated site.
+func manifestFile(files []File, generated time.Time, revision string) (File, error) {
+	m := manifest{Generated: generated, Revision: revision, Pages: []manifestPage{}}
+	for _, f := range files {
+		if f.page == nil {
+			continue
+		}
+		p := manifestPage{
+			ImportPath:   f.page.ImportPath,
+			ImportPrefix: f.page.GoImport.ImportPrefix,
+			VCS:          f.page.GoImport.VCS,
+			RepoRoot:     f.page.GoImport.RepoRoot,
+			Subdir:       f.page.GoImport.Subdir,
+			File:         f.path,
+		}
+		if s := f.page.GoSource; s != nil {
+			p.GoSource = &manifestSource{s.Home, s.Directory, s.File}
+		}
+		m.Pages = append(m.Pages, p)
+	}
+	sort.Slice(m.Pages, func(i, j int) bool {
+		if m.Pages[i].ImportPath != m.Pages[j].ImportPath {
+			return m.Pages[i].ImportPath < m.Pages[j].ImportPath
+		}
+		return m.Pages[i].File < m.Pages[j].File
+	})
+	return jsonFile(manifestName, m)
+}
+
+// govanityFile returns the configuration for govanityurls serving the
+// import prefixes of the package pages among files at the vanity domain
+// host, for those moving to it. govanityurls serves a single domain, and
+// doesn't name the subdirectories of modules in go-import tags, so import
+// prefixes that need either are left out with a warning.
+func govanityFile(files []File, host string) File {
+	paths := make(map[string]*TemplateArgs)
+	for _, f := range files {
+		if f.page == nil {
+			continue
+		}
+		prefix := f.page.GoImport.ImportPrefix
+		if _, ok := paths[prefix]; ok {
+			continue
+		}
+		switch {
+		case prefix != host && !strings.HasPrefix(prefix, host+"/"):
+			warnf("leaving %s, not under %s, out of %s", prefix, host, govanityName)
+		case f.page.GoImport.Subdir != "":
+			warnf("leaving %s, in subdirectory %s, out of %s", prefix, f.page.GoImport.Subdir, govanityName)
+		default:
+			paths[prefix] = f.page
+			continue
+		}
+		paths[prefix] = nil
+	}
+	var prefixes []string
+	for prefix, page := range paths {
+		if page != nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	f := File{path: govanityName}
+	fmt.Fprintf(&f.contents, "host: %s\npaths:\n", host)
+	for _, prefix := range prefixes {
+		page := paths[prefix]
+		p := strings.TrimPrefix(prefix, host)
+		if p == "" {
+			p = "/"
+		}
+		fmt.Fprintf(&f.contents, "  %s:\n", strconv.Quote(p))
+		fmt.Fprintf(&f.contents, "    repo: %s\n", strconv.Quote(page.GoImport.RepoRoot))
+		fmt.Fprintf(&f.contents, "    vcs: %s\n", page.GoImport.VCS)
+		if s := page.GoSource; s != nil {
+			display := strings.Join([]string{s.Home, s.Directory, s.File}, " ")
+			fmt.Fprintf(&f.contents, "    display: %s\n", strconv.Quote(display))
+		}
+	}
+	return f
+}
@@ -7,37 +7,317 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-
-	git "gopkg.in/src-d/go-git.v3"
-	gitcore "gopkg.in/src-d/go-git.v3/core"
+	texttemplate "text/template"
+	"time"
+	"unicode"
 )
 
-const help = `usage: metaimport [-branch branch] [-godoc] [-o dir] [-redirect] <import-prefix> <repo>
+const help = `usage: metaimport [flags] <import-prefix> <repo>
+       metaimport check [flags] <domain>
+       metaimport lint-template <file>
+       metaimport rollback [flags] [domain]
+       metaimport version [-check-update]
 
 metaimport generates HTML files with <meta name="go-import"> tags as expected
-by go get. 'repo' specifies the Git repository containing Go source code to
-generate meta tags for. 'import-prefix' is the import path corresponding to
-the repository root.
+by go get. 'repo' specifies the repository containing Go source code to
+generate meta tags for: its URL, Launchpad's lp: shorthand included, or the
+path or file URL of a local clone or bare repository, whose origin remote
+URL is advertised; -public-url is required if it has none. A directory in a
+clone or worktree stands for the clone, whose checked-out branch, or commit
+if HEAD is detached, is used by default. 'import-prefix' is the import path
+corresponding to the repository root.
+
+If the repository root has a go.work file, packages in workspace modules
+whose module path doesn't follow the repository layout get pages under the
+module path instead, with go-import tags naming the module's subdirectory.
+Only Go 1.25 and later understand such tags; earlier versions of the go
+command fail to fetch those modules.
+
+An argument of the form @file is replaced by the whitespace-separated
+arguments read from file. Arguments are quoted as in the shell: with single
+quotes, inside which every character is literal, or double quotes, inside
+which a backslash escapes a double quote or backslash, as in
+-git-header "Authorization: Bearer $TOKEN". Outside quotes, a backslash
+escapes the next character. Quotes don't span lines. Blank lines and lines
+beginning with '#' in the file are ignored.
+
+The check command verifies the setup of the vanity domain. See
+'metaimport check -h'. The rollback command restores the output of the
+previous generation, saved with -snapshots. See 'metaimport rollback -h'.
+The lint-template command checks a custom page template. See
+'metaimport lint-template -h'.
 
 Flags
-   -branch    Branch to use (default: remote's default branch).
-   -godoc     Include <meta name="go-source"> tag as expected by godoc.org (default: false).
-              Only partial support for repositories not hosted on github.com.
-   -o         Output directory for generated HTML files (default: html).
-              The directory is created with 0755 permissions if it doesn't exist.
-   -redirect  Redirect to godoc.org documentation when visited in a browser (default: true).
+   -api                 Also generate api/index.json describing, for every import prefix
+                        served by the site, including those of workspace modules, its
+                        repository, subdirectory, latest version and packages.
+                        Entries for other import prefixes are kept from earlier runs. Only
+                        unprefixed version tags are considered, so workspace modules, whose
+                        tags have the form dir/vX.Y.Z, have no latest version (default: false).
+   -branch              Branch to use (default: the branch the remote's HEAD points to, such
+                        as main or master). With -vcs svn, the path of the branch or tag
+                        relative to the repository URL, such as branches/v2 (default: the
+                        repository URL itself).
+   -branch-prefix       Also generate pages for the import prefix from the tree of the branch,
+                        given as branch=import-prefix, for example dev=dev.example.org/x.
+                        Repeatable. The pages are written alongside those of the main import
+                        prefix; -versions, -feed, -api and -platform describe only the
+                        main import prefix.
+   -build-constraints   Ignore Go files that are excluded, by build constraints or file name
+                        suffixes, on all common platforms, and test files. Directories with no
+                        other Go files are skipped (default: false).
+   -ca-cert             PEM file of CA certificates, in addition to the system's, to verify
+                        the certificates of git servers with when fetching over https, such
+                        as those of self-hosted servers (default: none).
+   -cache-dir           Keep a mirror of each git repository in the directory, and update it
+                        on later runs instead of fetching the repository anew. The mirror is
+                        fetched with the git command, so its configuration, such as
+                        credential helpers, applies too (default: none).
+   -canonical           Add a <link rel="canonical"> tag to each page, with the https URL of
+                        its import path, so that search engines don't index the pages under
+                        other hosts that serve the site (default: false).
+   -cname               Also generate the CNAME file that GitHub Pages reads the custom domain
+                        of the site from, the vanity domain of the import prefix, and a
+                        .nojekyll file, for hosting the site on GitHub Pages (default: false).
+   -cpuprofile          Write a CPU profile to the named file, for use with
+                        'go tool pprof' (default: none).
+   -depth               Number of commits of history to fetch with -vcs git. Only the latest
+                        commits are read, so 0, which fetches the full history, is rarely
+                        needed (default: 1).
+   -deploy              Deploy the generated site after writing it: "netlify" or "cloudflare"
+                        (Cloudflare Pages). Both require -site and credentials in the environment.
+   -docsite             Documentation service the pages redirect to and link to: "pkggodev"
+                        (pkg.go.dev), "godoc" (godoc.org, which is deprecated, as earlier
+                        versions did), or "none" for pages without documentation links that
+                        don't redirect (default: pkggodev).
+   -feed                Also generate Atom feeds of the repository's semantic version tags:
+                        one at <import-prefix>/@versions/feed.atom, and one for the site at
+                        feed.atom, which keeps the entries of other modules from earlier runs.
+                        Prefixed tags of workspace modules, such as dir/vX.Y.Z, aren't
+                        included (default: false).
+   -git-header          Extra HTTP header, given as "Name: value", to send with git fetches over
+                        http and https, for example "Authorization: Bearer $TOKEN". Environment
+                        variables in the value are expanded. Sent only to the host of the
+                        repository, not to those of submodules and mirrors. Repeatable.
+   -git-suffix          Either "strip" or "append" the ".git" suffix in the repository
+                        root advertised in the tags (default: leave unchanged).
+   -godoc               Include <meta name="go-source"> tag as expected by godoc.org (default: false).
+                        Only partial support for repositories not hosted on github.com,
+                        gitlab.com, codeberg.org, gitea.com, *.googlesource.com,
+                        Launchpad, Azure DevOps or AWS CodeCommit.
+   -head-include        HTML file whose contents are added to the <head> of each page, such as
+                        analytics scripts or domain verification tags. The content security
+                        policy of -headers allows only the site's own resources and inline
+                        scripts and styles, so it blocks resources loaded from other origins,
+                        such as the script of an analytics service (default: none).
+   -headers             Also generate a _headers file, read by Netlify and Cloudflare Pages,
+                        that sets the caching, content type and security headers for the
+                        site (default: false).
+   -https               Use the https scheme in the advertised repository root, converting
+                        SSH URLs and user@host:path addresses (default: false).
+   -include-dot         Include directories beginning with "." (default: false).
+   -include-testdata    Include directories named "testdata" (default: false).
+   -include-underscore  Include directories beginning with "_" (default: false).
+   -index               Make the page for the import prefix a landing page listing the
+                        packages, with links to their documentation and, with -godoc, their
+                        source, that doesn't redirect (default: false).
+   -insecure-skip-verify
+                        Don't verify the TLS certificates of git servers when fetching over
+                        https. Prefer -ca-cert (default: false).
+   -log-level           Minimum severity of the messages logged: "error", "warn", "info"
+                        or "debug" (default: info). At "debug", ref resolution and the tree
+                        walk are traced.
+   -memprofile          Write a heap profile, taken on exit, to the named file (default: none).
+   -manifest            Also write a manifest of the generated pages to the output directory,
+                        outside the site, in the named format: "json", for manifest.json
+                        listing the import path, repository root, VCS, output file and
+                        go-source formats of each page, or "govanityurls", for the
+                        vanity.yaml configuration of govanityurls (default: none).
+   -mirror              URL of a mirror of the repository to read it from if fetching the
+                        repository fails. Repeatable; mirrors are tried in order. The
+                        repository URL is still advertised (default: none).
+   -no-fetch            Read only repositories on disk, so that nothing is fetched over the
+                        network: the repository must be the path of a local repository,
+                        and so must its mirrors and submodules. Can't be used with -deploy
+                        or -verify (default: false).
+   -not-found           Also generate a 404.html, which GitHub Pages, Netlify and Cloudflare
+                        Pages serve for missing paths, with the go-import tags of the import
+                        prefixes of the site, so that go get resolves import paths without
+                        pages of their own. Tags of other import prefixes are kept from
+                        earlier runs (default: false).
+   -o                   Output directory for generated HTML files, or, if the name ends in
+                        .tar.gz, .tgz or .zip, archive to write them to (default: html).
+                        The directory is created with 0755 permissions if it doesn't exist.
+   -path                Import path of the page to print with -stdout (default: the
+                        import prefix).
+   -platform            Also write the configuration needed to serve the site on a hosting
+                        platform: "azure" (Azure Static Web Apps), "fastly" (the source of
+                        a Fastly Compute service, written to the fastly directory), "haproxy"
+                        (a map file and configuration snippet, written to the haproxy directory)
+                        or "vercel" (a vercel.json, so that running vercel deploy in the
+                        directory of the site deploys it).
+   -proxy               Proxy to fetch repositories through over http and https, given as an
+                        http, https or socks5 URL. Overrides HTTPS_PROXY, HTTP_PROXY and
+                        NO_PROXY, which are honored otherwise (default: none).
+   -proxy-url           Advertise the module proxy at the URL, using the "mod" VCS, instead of
+                        the repository, which is still read to determine the packages. The
+                        proxy, such as an Athens server, must serve the import prefix and
+                        any workspace modules (default: none).
+   -public-url          Repository URL to advertise in the go-import tag instead of the one
+                        read from, such as an https URL when reading over SSH. go-source
+                        links are derived from it too (default: the repository URL, or the
+                        origin remote of a local git repository, in https form if it is an
+                        SCP-like address).
+   -quiet               Log errors only, same as -log-level error (default: false).
+   -redirect            Redirect to the documentation, as given by -docsite or -redirect-url,
+                        when visited in a browser (default: true).
+   -redirect-js         Redirect using JavaScript instead of <meta http-equiv="refresh">. The
+                        redirect is skipped when the URL has the go-get=1 query parameter or
+                        the #no-redirect fragment, so the page can be inspected (default: false).
+   -redirect-url        Template of the URL of the documentation of a package, which the pages
+                        redirect to and link to, in place of that of -docsite, such as the
+                        URL of an internal documentation server. The text/template is
+                        executed with the import path of the package as .ImportPath, as in
+                        https://pkg.go.dev/{{ .ImportPath }} (default: that of -docsite).
+   -redirects           Also generate a _redirects file, read by Netlify and Cloudflare Pages,
+                        that serves the page for each import prefix at the paths under it
+                        without pages of their own, such as those of packages added since
+                        the site was generated. The rules of other import prefixes are kept
+                        from earlier runs. See also -headers (default: false).
+   -retries             Number of times to retry fetching the repository, after 1s, 2s, 4s
+                        and so on, before giving up or trying the next -mirror (default: 0).
+   -rev                 Revision to use instead of a branch, for reproducible pages. With -vcs
+                        git, a full commit hash, which the remote must allow to be fetched
+                        (default: none).
+   -robots              Also generate a robots.txt for the site that either lets crawlers
+                        index the pages, "allow", or keeps them away, "deny", naming the
+                        sitemap of -sitemap if allowed (default: none).
+   -single-page         Generate only the page for the import prefix, without fetching the
+                        repository, for web servers that serve the page for every path
+                        under the import prefix, as go get only needs the import prefix
+                        in the go-import tag. The go-source tag of -godoc links to the
+                        source of directories and files only with -branch, -tag or -rev
+                        (default: false).
+   -site                Site to deploy to: the Netlify site ID or domain, or the Cloudflare
+                        Pages project name.
+   -sitemap             Also generate a sitemap.xml listing the URLs of the pages. URLs under
+                        other import prefixes are kept from earlier runs (default: false).
+   -skip-generated      Skip directories whose Go files are all generated, as marked by a
+                        "Code generated ... DO NOT EDIT." comment (default: false).
+   -snapshots           Before writing, save a timestamped copy of the output directory in
+                        the named directory, for 'metaimport rollback'. The 10 most recent
+                        snapshots are kept. It must not be inside the output directory
+                        (default: none).
+   -source-dir          Template of the URLs of directories in the go-source tag of -godoc,
+                        in which {dir} or {/dir} stands for the directory, for hosts whose
+                        formats aren't known (default: detected from the host).
+   -source-file         Template of the URLs of lines of files in the go-source tag of
+                        -godoc, in which {dir} or {/dir} stands for the directory, {file}
+                        for the file name and {line} for the line number (default: detected
+                        from the host).
+   -source-home         URL of the home page of the repository in the go-source tag of
+                        -godoc, or "_" for the repository root (default: detected from the
+                        host).
+   -source-host         Software hosting the repository, for the go-source tag of -godoc, for
+                        self-hosted servers under other names: "github" (GitHub Enterprise),
+                        "gitlab", "gitea", "forgejo", "gogs", "gitiles", "gerrit" (Gerrit
+                        with its Gitiles plugin), "cgit", "gitweb" (served at /gitweb/) or
+                        "bitbucket-server" (Bitbucket Data Center) (default: detected from
+                        the host).
+   -source-hosts        File of further hosts whose go-source formats are known for -godoc,
+                        each line naming a host and either the kind of its software, as
+                        -source-host does, or its home, directory and file templates, in
+                        which {repo} stands for the repository URL and {ref} for the
+                        branch, tag or revision. Lines beginning with '#' are ignored
+                        (default: none).
+   -ssh-key             Private key file to authenticate with when fetching over SSH, from
+                        ssh:// URLs or user@host:path addresses, in addition to the keys of
+                        ssh-agent. Host keys are verified against ~/.ssh/known_hosts
+                        (default: none).
+   -stdout              Print the page of the package at -path to standard output instead
+                        of writing any files (default: false).
+   -storage             Where to keep the objects fetched with -vcs git: "memory", or "disk"
+                        for repositories too large to hold in memory, at the cost of
+                        speed (default: memory).
+   -strict              Treat warnings, such as a missing license file, as errors (default: false).
+   -submodules          Also look for Go packages in the submodules of the git repository,
+                        fetching each at the commit recorded in the tree. Relative submodule
+                        URLs are resolved against the repository URL (default: false).
+   -synopsis            Show the synopsis of each package, from its doc comment, on its page
+                        and in the list of -index (default: false).
+   -tag                 Tag to use instead of a branch, such as v1.2.3. With -vcs svn, the
+                        tag is read from the tags directory (default: none).
+   -template            File of the html/template to generate the page of each package with,
+                        in place of the built-in one. See 'metaimport lint-template -h' for
+                        the data it is executed with (default: the built-in template).
+   -timeout             Abort fetching the repository when the remote sends nothing for the
+                        duration, such as 30s or 2m, so that a stalled fetch fails, and
+                        can be retried with -retries, instead of hanging. Applies to git
+                        fetches with -vcs git; 0 means no timeout (default: 0).
+   -token               Access token to authenticate with when fetching over https, sent with
+                        the user name the host expects: x-access-token for github.com,
+                        oauth2 for gitlab.com and other hosts, x-token-auth for
+                        bitbucket.org, or the user name in the repository URL
+                        (default: $METAIMPORT_TOKEN). The token is sent only to the host
+                        of the repository, not to those of submodules and mirrors. Without
+                        a token, the credentials for the host in ~/.netrc, if any, are used.
+   -trace               Write an execution trace to the named file, for use with
+                        'go tool trace' (default: none).
+   -tree-only           Fetch only the trees of the git repository, without the contents of
+                        its files, which are fetched when read: go.mod, go.work and license
+                        files, and Go files with -build-constraints or -skip-generated. Makes
+                        large repositories much faster to read. The remote must support
+                        partial clones; otherwise the files are fetched too (default: false).
+   -trim-slash          Drop trailing slashes from the advertised repository root (default: false).
+   -use-git-binary      Check out git repositories with the git command instead of fetching
+                        them with the built-in client, so that git's configuration, such as
+                        credential helpers, proxies and GIT_SSH_COMMAND, applies. Can't be
+                        used with -versions, -feed or -api (default: false).
+   -vcs                 Version control system of the repository: "git", "hg", "svn", "bzr"
+                        or "fossil" (default: git).
+                        Repositories other than git ones are checked out with the VCS's
+                        command, which must be installed, and -versions, -feed and -api
+                        are unavailable.
+   -verify              After writing and deploying the site, verify that the import prefix
+                        resolves as a module through proxy.golang.org (default: false).
+   -versions            Also generate a page at <import-prefix>/@versions listing the
+                        repository's semantic version tags. Tags of major versions other than
+                        that of the /vN suffix of the import prefix, or v0 and v1 without one,
+                        are skipped, as they aren't versions of the module, and so are tags
+                        with build metadata and the prefixed tags of workspace modules, such
+                        as dir/vX.Y.Z (default: false).
+
+Environment
+   CLOUDFLARE_ACCOUNT_ID  Cloudflare account ID used by -deploy cloudflare.
+   CLOUDFLARE_API_TOKEN   Cloudflare API token used by -deploy cloudflare.
+   GIT_SSH_COMMAND        Command used to connect to the remote when fetching over SSH, as
+                          git does. The built-in client, with ssh-agent and -ssh-key, is
+                          used otherwise. GIT_SSH is honored too.
+   HTTPS_PROXY            Proxy for fetching repositories over https, unless -proxy is given.
+                          HTTP_PROXY and NO_PROXY are honored too.
+   METAIMPORT_TOKEN       Default for -token.
+   NETLIFY_AUTH_TOKEN     Netlify personal access token used by -deploy netlify.
+   NETRC                  Path of the .netrc file with credentials for https fetches
+                          (default: ~/.netrc).
+   SSH_AUTH_SOCK          Socket of the ssh-agent whose keys are used when fetching over SSH.
 
 Examples
    metaimport example.org/myrepo https://github.com/user/myrepo
    metaimport example.org/exproj http://code.org/r/p/exproj
+   metaimport -git-suffix strip -https example.org/myrepo http://github.com/user/myrepo.git/
+   metaimport @args.txt
+   metaimport -deploy netlify -site mysite example.org/myrepo https://github.com/user/myrepo
+   metaimport -branch-prefix dev=dev.example.org/myrepo example.org/myrepo https://github.com/user/myrepo
 `
 
 func usage() {
@@ -45,6 +325,15 @@ func usage() {
 	os.Exit(2)
 }
 
+// commands maps subcommand names to their implementations, which are
+// passed the remaining arguments.
+var commands = map[string]func(args []string){
+	"check":         runCheck,
+	"lint-template": runLintTemplate,
+	"rollback":      runRollback,
+	"version":       runVersion,
+}
+
 const (
 	permDir  = 0755
 	permFile = 0644
@@ -56,113 +345,722 @@ func main() {
 
 	godoc := flag.Bool("godoc", false, "")
 	branch := flag.String("branch", "", "")
+	tag := flag.String("tag", "", "")
+	templateFile := flag.String("template", "", "")
+	manifestFormat := flag.String("manifest", "", "")
+	stdout := flag.Bool("stdout", false, "")
+	singlePage := flag.Bool("single-page", false, "")
+	sitemapFlag := flag.Bool("sitemap", false, "")
+	robots := flag.String("robots", "", "")
+	index := flag.Bool("index", false, "")
+	notFound := flag.Bool("not-found", false, "")
+	synopsis := flag.Bool("synopsis", false, "")
+	canonical := flag.Bool("canonical", false, "")
+	headInclude := flag.String("head-include", "", "")
+	cname := flag.Bool("cname", false, "")
+	redirects := flag.Bool("redirects", false, "")
+	pagePath := flag.String("path", "", "")
+	rev := flag.String("rev", "", "")
 	outputDir := flag.String("o", "", "")
 	godocRedirect := flag.Bool("redirect", true, "")
+	redirectJS := flag.Bool("redirect-js", false, "")
+	redirectURLText := flag.String("redirect-url", "", "")
+	docSite := flag.String("docsite", "pkggodev", "")
+	gitSuffix := flag.String("git-suffix", "", "")
+	forceHTTPS := flag.Bool("https", false, "")
+	trimSlash := flag.Bool("trim-slash", false, "")
+	var filter dirFilter
+	flag.BoolVar(&filter.dot, "include-dot", false, "")
+	flag.BoolVar(&filter.testdata, "include-testdata", false, "")
+	flag.BoolVar(&filter.underscore, "include-underscore", false, "")
+	flag.BoolVar(&filter.skipGenerated, "skip-generated", false, "")
+	flag.BoolVar(&filter.constraints, "build-constraints", false, "")
+	strict := flag.Bool("strict", false, "")
+	logLevelName := flag.String("log-level", "info", "")
+	quiet := flag.Bool("quiet", false, "")
+	deployTarget := flag.String("deploy", "", "")
+	site := flag.String("site", "", "")
+	platform := flag.String("platform", "", "")
+	verify := flag.Bool("verify", false, "")
+	versions := flag.Bool("versions", false, "")
+	feed := flag.Bool("feed", false, "")
+	api := flag.Bool("api", false, "")
+	headers := flag.Bool("headers", false, "")
+	snapshotDir := flag.String("snapshots", "", "")
+	vcs := flag.String("vcs", "git", "")
+	flag.StringVar(&sshKeyFile, "ssh-key", "", "")
+	flag.IntVar(&fetchDepth, "depth", 1, "")
+	flag.StringVar(&cacheDir, "cache-dir", "", "")
+	flag.StringVar(&storageKind, "storage", "memory", "")
+	flag.BoolVar(&withSubmodules, "submodules", false, "")
+	flag.BoolVar(&treeOnly, "tree-only", false, "")
+	flag.BoolVar(&noFetch, "no-fetch", false, "")
+	flag.DurationVar(&fetchTimeout, "timeout", 0, "")
+	flag.IntVar(&fetchRetries, "retries", 0, "")
+	flag.StringVar(&gitToken, "token", os.Getenv("METAIMPORT_TOKEN"), "")
+	proxyURL := flag.String("proxy-url", "", "")
+	publicURLFlag := flag.String("public-url", "", "")
+	sourceHost := flag.String("source-host", "", "")
+	sourceHostsFile := flag.String("source-hosts", "", "")
+	flag.StringVar(&sourceHomeURL, "source-home", "", "")
+	flag.StringVar(&sourceDirURL, "source-dir", "", "")
+	flag.StringVar(&sourceFileURL, "source-file", "", "")
+	proxyFlag := flag.String("proxy", "", "")
+	flag.StringVar(&gitCACert, "ca-cert", "", "")
+	flag.BoolVar(&gitInsecure, "insecure-skip-verify", false, "")
+	useGitBinary := flag.Bool("use-git-binary", false, "")
+	gitHeader := make(headerFlags)
+	flag.Var(gitHeader, "git-header", "")
+	var branchPrefixList branchPrefixes
+	flag.Var(&branchPrefixList, "branch-prefix", "")
+	var mirrors mirrorList
+	flag.Var(&mirrors, "mirror", "")
+	var prof profiling
+	flag.StringVar(&prof.cpu, "cpuprofile", "", "")
+	flag.StringVar(&prof.mem, "memprofile", "", "")
+	flag.StringVar(&prof.trace, "trace", "", "")
 
 	flag.Usage = usage
-	flag.Parse()
+	expanded, err := expandArgFiles(os.Args[1:])
+	if err != nil {
+		fatalf("reading arguments: %s", err)
+	}
+	if len(expanded) > 0 {
+		if cmd, ok := commands[expanded[0]]; ok {
+			cmd(expanded[1:])
+			return
+		}
+	}
+	flag.CommandLine.Parse(expanded)
 
 	args := flag.Args()
 	if len(args) != 2 {
 		usage()
 	}
+	if *outputDir == "" {
+		*outputDir = "html"
+	}
+	if err := setLogLevel(*logLevelName, *quiet); err != nil {
+		fatalf("%s", err)
+	}
+	stopProfiling, err := prof.start()
+	if err != nil {
+		fatalf("starting profiling: %s", err)
+	}
+	// fatalf skips the deferred call, and profiles not stopped are cut short.
+	atExit(func() {
+		if err := stopProfiling(); err != nil {
+			warnf("stopping profiling: %s", err)
+		}
+	})
+	defer func() {
+		if err := stopProfiling(); err != nil {
+			fatalf("stopping profiling: %s", err)
+		}
+	}()
 
 	baseImportPrefix := args[0]
 	repoURL := args[1]
+	vanity := newSite(baseImportPrefix)
+	// bzr understands Launchpad's lp: shorthand, but git and go get don't.
+	lpURL, isLaunchpad := expandLaunchpadURL(repoURL, *vcs)
+	if isLaunchpad && *vcs != "bzr" {
+		repoURL = lpURL
+	}
+	// A local git repository, bare or not, is read from disk, and the URL
+	// of its origin remote is advertised, unless -public-url is given. A
+	// bare repository served from disk may have no origin, and go get
+	// doesn't fetch file URLs, so -public-url is required then. Other VCSs
+	// read local repositories themselves.
+	publicURL := repoURL
+	if isLaunchpad {
+		publicURL = lpURL
+	}
+	if *publicURLFlag != "" {
+		publicURL = *publicURLFlag
+	}
+	if dir, ok := localRepoPath(repoURL); ok && *vcs == "git" && *publicURLFlag == "" {
+		origin, err := originURL(dir)
+		if err == nil {
+			publicURL, err = advertisedOriginURL(dir, origin)
+		}
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+	repoRoot, err := normalizeRepoRoot(publicURL, *gitSuffix, *trimSlash, *forceHTTPS)
+	if err != nil {
+		fatalf("normalizing repository root: %s", err)
+	}
 	htmlTmpl := template.Must(template.New("").Parse(tmpl))
-	useDefaultBranch := *branch == ""
+	if *templateFile != "" {
+		b, err := ioutil.ReadFile(*templateFile)
+		if err != nil {
+			fatalf("reading template: %s", err)
+		}
+		if htmlTmpl, err = template.New(filepath.Base(*templateFile)).Parse(string(b)); err != nil {
+			fatalf("parsing template: %s", err)
+		}
+	}
 
-	repo, err := git.NewRepository(repoURL, nil)
-	if err != nil {
-		log.Fatalf("making repository: %s", err)
+	var headHTML template.HTML
+	if *headInclude != "" {
+		b, err := ioutil.ReadFile(*headInclude)
+		if err != nil {
+			fatalf("reading -head-include: %s", err)
+		}
+		headHTML = template.HTML(strings.TrimSpace(string(b)))
 	}
 
-	// Pull branch.
-	if useDefaultBranch {
-		err = repo.PullDefault()
-	} else {
-		err = repo.Pull(git.DefaultRemoteName, fmt.Sprintf("refs/heads/%s", *branch))
+	docSiteURL, ok := docSites[*docSite]
+	if !ok {
+		fatalf("unknown docsite %q", *docSite)
 	}
-	if err != nil {
-		log.Fatalf("pulling branch: %s", err)
+	if *redirectURLText != "" {
+		if *docSite == "none" {
+			fatalf("-redirect-url can't be used with -docsite none")
+		}
+		docSiteURL = *redirectURLText
+	}
+	// The template is nil with -docsite none.
+	var redirectURLTmpl *texttemplate.Template
+	if docSiteURL != "" {
+		if redirectURLTmpl, err = parseRedirectURL(docSiteURL); err != nil {
+			fatalf("parsing -redirect-url: %s", err)
+		}
 	}
 
-	// Get the tree for the HEAD of the branch.
-	var head gitcore.Hash
-	if useDefaultBranch {
-		head, err = repo.Head(git.DefaultRemoteName)
-	} else {
-		head, err = repo.Remotes[git.DefaultRemoteName].Ref(fmt.Sprintf("refs/heads/%s", *branch))
+	if _, ok := platforms[*platform]; *platform != "" && !ok {
+		fatalf("unknown platform %q", *platform)
 	}
-	if err != nil {
-		log.Fatalf("getting HEAD: %s", err)
+	if *pagePath != "" && !*stdout {
+		fatalf("-path requires -stdout")
 	}
-	headCommit, err := repo.Commit(head)
-	if err != nil {
-		log.Fatalf("getting HEAD commit: %s", err)
+	if isArchive(*outputDir) && (*deployTarget != "" || *snapshotDir != "") {
+		fatalf("-deploy and -snapshots can't be used with an archive as the output")
+	}
+	if *snapshotDir != "" {
+		if err := checkSnapshotDir(*outputDir, *snapshotDir); err != nil {
+			fatalf("%s", err)
+		}
+	}
+	if *stdout && (*deployTarget != "" || *verify) {
+		fatalf("-deploy and -verify can't be used with -stdout")
 	}
-	tree := headCommit.Tree()
+	if *robots != "" && *robots != "allow" && *robots != "deny" {
+		fatalf("invalid -robots value %q: want allow or deny", *robots)
+	}
+	if *manifestFormat != "" && *manifestFormat != "json" && *manifestFormat != "govanityurls" {
+		fatalf("unknown manifest format %q", *manifestFormat)
+	}
+	if *sourceHostsFile != "" {
+		if err := readSourceHosts(*sourceHostsFile); err != nil {
+			fatalf("reading source hosts: %s", err)
+		}
+	}
+	if *sourceHost != "" && !forcibleSourceHosts[*sourceHost] {
+		fatalf("unknown source host %q", *sourceHost)
+	}
+	if sourceDirURL != "" && !strings.Contains(sourceDirURL, "{dir}") && !strings.Contains(sourceDirURL, "{/dir}") {
+		fatalf("-source-dir %q has neither {dir} nor {/dir}", sourceDirURL)
+	}
+	if sourceFileURL != "" && !strings.Contains(sourceFileURL, "{file}") {
+		fatalf("-source-file %q has no {file}", sourceFileURL)
+	}
+	if *proxyURL != "" {
+		u, err := url.Parse(*proxyURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fatalf("invalid -proxy-url %q: want an http or https URL", *proxyURL)
+		}
+		*proxyURL = strings.TrimSuffix(*proxyURL, "/")
+	}
+	newBackend, ok := backends[*vcs]
+	if !ok {
+		fatalf("unknown VCS %q", *vcs)
+	}
+	if storageKind != "memory" && storageKind != "disk" {
+		fatalf("unknown storage %q", storageKind)
+	}
+	if (*branch != "" && *tag != "") || (*rev != "" && *branch+*tag != "") {
+		fatalf("only one of -branch, -tag and -rev can be given")
+	}
+	if fetchDepth < 0 {
+		fatalf("invalid -depth %d", fetchDepth)
+	}
+	if noFetch {
+		if _, ok := localRepoPath(repoURL); !ok {
+			fatalf("-no-fetch requires the path of a local repository")
+		}
+		if *deployTarget != "" || *verify {
+			fatalf("-deploy and -verify can't be used with -no-fetch")
+		}
+		if *useGitBinary && withSubmodules {
+			fatalf("-submodules can't be used with -no-fetch and -use-git-binary")
+		}
+	}
+	if fetchTimeout < 0 {
+		fatalf("invalid -timeout %s", fetchTimeout)
+	}
+	if fetchRetries < 0 {
+		fatalf("invalid -retries %d", fetchRetries)
+	}
+	if *singlePage && (*versions || *feed || *api || withSubmodules) {
+		fatalf("-versions, -feed, -api and -submodules can't be used with -single-page")
+	}
+	if *vcs != "git" && (*versions || *feed || *api) {
+		fatalf("-versions, -feed and -api require -vcs git")
+	}
+	if *vcs != "git" && withSubmodules {
+		fatalf("-submodules requires -vcs git")
+	}
+	if *vcs != "git" && treeOnly {
+		fatalf("-tree-only requires -vcs git")
+	}
+	if *useGitBinary {
+		if *vcs != "git" {
+			fatalf("-use-git-binary requires -vcs git")
+		}
+		if *versions || *feed || *api {
+			fatalf("-versions, -feed and -api can't be used with -use-git-binary")
+		}
+		if treeOnly {
+			fatalf("-tree-only can't be used with -use-git-binary")
+		}
+		newBackend = newCmdBackend(vcsGit)
+	}
+
+	var dep deployer // can be nil
+	if *deployTarget != "" {
+		dep, err = newDeployer(*deployTarget, *site)
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	var proxy *url.URL
+	if *proxyFlag != "" {
+		proxy, err = url.Parse(*proxyFlag)
+		if err != nil || (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") || proxy.Host == "" {
+			fatalf("invalid -proxy %q: want an http, https or socks5 URL", *proxyFlag)
+		}
+	}
+	if u, err := url.Parse(repoURL); err == nil {
+		gitAuthHost = u.Hostname()
+	}
+	if err := configureGitHTTP(http.Header(gitHeader), proxy); err != nil {
+		fatalf("configuring git fetches: %s", err)
+	}
+	// The repository is read from the first of it and its mirrors that can
+	// be fetched, but the advertised root is always the repository's.
+	ref := treeRef{branch: *branch, tag: *tag, rev: *rev}
+	// With -single-page, the repository isn't fetched at all.
+	var (
+		backend vcsBackend = unfetchedBackend{}
+		tree    sourceTree = emptyTree{}
+		head    string
+	)
+	if !*singlePage {
+		backend, tree, head, err = openTree(newBackend, append([]string{repoURL}, mirrors...), ref)
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+	// Remove any temporary checkout or storage of the repository, on errors
+	// too.
+	atExit(func() { backend.close() })
+	defer backend.close()
+	resolvedBranch := ref.branch
+	switch def, ok := defaultBranch(backend); {
+	case *singlePage:
+	case ref.rev != "":
+		infof("using revision %s", head)
+	case ref == (treeRef{}) && ok:
+		infof("using default branch %s at revision %s", def, head)
+		resolvedBranch = def
+	default:
+		infof("using %s at revision %s", ref, head)
+	}
+	generated := time.Now().UTC().Truncate(time.Second)
 
 	// Determine the Go package directories.
-	dirs, err := packageDirs(tree)
+	dirs, err := packageDirs(tree, filter)
+	if err != nil {
+		fatalf("determining go package directories: %s", err)
+	}
+	// Determine the modules of the go.work workspace, if any.
+	mods, err := workspaceModules(tree)
 	if err != nil {
-		log.Fatalf("determining go package directories: %s", err)
+		fatalf("determining workspace modules: %s", err)
 	}
 
-	var godocSpec GodocSpec // can be nil
+	// Warn about modules that pkg.go.dev won't display documentation for,
+	// since the pages redirect there.
+	moduleDirs := []string{"."}
+	if len(mods) > 0 {
+		moduleDirs = moduleDirs[:0]
+		for _, m := range mods {
+			moduleDirs = append(moduleDirs, m.dir)
+		}
+	}
+	for _, d := range moduleDirs {
+		if *singlePage {
+			break
+		}
+		if err := checkLicense(tree, d); err != nil {
+			if *strict {
+				fatalf("%s", err)
+			}
+			warnf("%s", err)
+		}
+	}
+
+	// Always generate the page for the repository root, even if it has no
+	// Go files, so that the base import prefix resolves.
+	dirs["."] = struct{}{}
+
+	// See https://npf.io/2016/10/vanity-imports-with-hugo/ and Issue#1
+	// on GitHub, for why the import prefix in the tags is the base import
+	// prefix for every package.
+	vanity.goImport = GoImport{
+		ImportPrefix: baseImportPrefix,
+		VCS:          *vcs,
+		RepoRoot:     repoRoot,
+	}
+	if *proxyURL != "" {
+		vanity.goImport.VCS = "mod"
+		vanity.goImport.RepoRoot = *proxyURL
+	}
+	vanity.redirect = *godocRedirect && redirectURLTmpl != nil
+	vanity.redirectURL = redirectURLTmpl
 	if *godoc {
-		godocSpec = determineGodocSpec(repoURL, *branch, useDefaultBranch, repo)
+		godocSpec := determineGodocSpec(repoRoot, *sourceHost, ref, backend)
+		vanity.goSource = &GoSource{
+			Prefix:    baseImportPrefix,
+			Home:      godocSpec.home(),
+			Directory: godocSpec.directory(),
+			File:      godocSpec.file(),
+		}
 	}
 
-	type File struct {
-		path     string
-		contents bytes.Buffer
+	var synopses map[string]string
+	if *synopsis {
+		if synopses, err = packageSynopses(tree, dirs); err != nil {
+			fatalf("determining package synopses: %s", err)
+		}
 	}
-	var files []File
 
-	for d := range dirs {
-		if d == "." {
-			d = ""
+	files, packages, err := packagePages(htmlTmpl, redirectURLTmpl, baseImportPrefix, dirs, synopses, mods, TemplateArgs{
+		GoImport:      vanity.goImport,
+		GoSource:      vanity.goSource,
+		GodocRedirect: vanity.redirect,
+		RedirectJS:    *redirectJS,
+		Branch:        resolvedBranch,
+		Revision:      head,
+		Generated:     generated,
+		HeadInclude:   headHTML,
+	}, *index, *canonical)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	vanity.packages = packages
+
+	// Generate the pages for the import prefixes of other branches.
+	for _, bp := range branchPrefixList {
+		tree, head, err := backend.tree(treeRef{branch: bp.branch})
+		if err != nil {
+			fatalf("%s", err)
+		}
+		infof("using revision %s for %s", head, bp.importPrefix)
+		dirs, err := packageDirs(tree, filter)
+		if err != nil {
+			fatalf("determining go package directories for %s: %s", bp.branch, err)
 		}
-		forwardSlashed := filepath.ToSlash(d)
-		fullImportPrefix := path.Join(baseImportPrefix, forwardSlashed)
-		file := File{path: fullImportPrefix}
+		mods, err := workspaceModules(tree)
+		if err != nil {
+			fatalf("determining workspace modules for %s: %s", bp.branch, err)
+		}
+		dirs["."] = struct{}{}
 
 		args := TemplateArgs{
-			// See https://npf.io/2016/10/vanity-imports-with-hugo/ and Issue#1
-			// on GitHub, for why this shouldn't be fullImportPrefix.
 			GoImport: GoImport{
-				ImportPrefix: baseImportPrefix,
-				VCS:          "git",
-				RepoRoot:     repoURL,
+				ImportPrefix: bp.importPrefix,
+				VCS:          vanity.goImport.VCS,
+				RepoRoot:     vanity.goImport.RepoRoot,
 			},
-			GodocURL:      fmt.Sprintf("https://godoc.org/%s", fullImportPrefix),
-			GodocRedirect: *godocRedirect,
+			GodocRedirect: vanity.redirect,
+			RedirectJS:    *redirectJS,
+			Branch:        bp.branch,
+			Revision:      head,
+			Generated:     generated,
+			HeadInclude:   headHTML,
 		}
 		if *godoc {
+			godocSpec := determineGodocSpec(repoRoot, *sourceHost, treeRef{branch: bp.branch}, backend)
 			args.GoSource = &GoSource{
-				Prefix:    baseImportPrefix,
+				Prefix:    bp.importPrefix,
 				Home:      godocSpec.home(),
 				Directory: godocSpec.directory(),
 				File:      godocSpec.file(),
 			}
 		}
+		var synopses map[string]string
+		if *synopsis {
+			if synopses, err = packageSynopses(tree, dirs); err != nil {
+				fatalf("determining package synopses for %s: %s", bp.branch, err)
+			}
+		}
+		bfiles, _, err := packagePages(htmlTmpl, redirectURLTmpl, bp.importPrefix, dirs, synopses, mods, args, *index, *canonical)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		files = append(files, bfiles...)
+	}
 
-		if err := htmlTmpl.Execute(&file.contents, args); err != nil {
-			log.Fatalf("executing template for path %s: %s", file.path, err)
+	if *stdout {
+		want := *pagePath
+		if want == "" {
+			want = baseImportPrefix
 		}
-		files = append(files, file)
+		for _, f := range files {
+			if f.page != nil && f.page.ImportPath == want {
+				if _, err := f.contents.WriteTo(os.Stdout); err != nil {
+					fatalf("%s", err)
+				}
+				return
+			}
+		}
+		fatalf("no page for %s", want)
 	}
 
-	// Make the output directory.
-	if *outputDir == "" {
-		*outputDir = "html"
+	if *versions || *feed || *api {
+		all, err := releases(backend.(*gitBackend).repo) // checked above
+		if err != nil {
+			fatalf("determining versions: %s", err)
+		}
+		rels := moduleReleases(baseImportPrefix, all)
+		if n := len(all) - len(rels); n > 0 {
+			warnf("skipping %d version tags of major versions other than that of %s", n, baseImportPrefix)
+		}
+		if *versions {
+			f, err := versionsFile(baseImportPrefix, rels)
+			if err != nil {
+				fatalf("executing versions template: %s", err)
+			}
+			files = append(files, f)
+		}
+		if *feed {
+			f, err := moduleFeedFile(baseImportPrefix, rels)
+			if err != nil {
+				fatalf("generating feed: %s", err)
+			}
+			files = append(files, f)
+			existing := filepath.Join(*outputDir, vanity.host, siteFeedName)
+			f, err = siteFeedFile(vanity, existing, baseImportPrefix, rels)
+			if err != nil {
+				fatalf("generating site feed: %s", err)
+			}
+			files = append(files, f)
+		}
+		if *api {
+			existing := filepath.Join(*outputDir, vanity.host, filepath.FromSlash(apiIndexName))
+			f, err := apiIndexFile(vanity, existing, rels)
+			if err != nil {
+				fatalf("generating API index: %s", err)
+			}
+			files = append(files, f)
+		}
+	}
+
+	if *headers {
+		files = append(files, headersFile(vanity))
+	}
+
+	if *notFound {
+		existing := filepath.Join(*outputDir, vanity.host, notFoundName)
+		f, err := notFoundFile(vanity, existing, files)
+		if err != nil {
+			fatalf("generating %s: %s", notFoundName, err)
+		}
+		files = append(files, f)
+	}
+
+	if *redirects {
+		existing := filepath.Join(*outputDir, vanity.host, redirectsName)
+		f, err := redirectsFile(vanity, existing, files)
+		if err != nil {
+			fatalf("generating %s: %s", redirectsName, err)
+		}
+		files = append(files, f)
+	}
+
+	if *robots != "" {
+		files = append(files, robotsFile(vanity, *robots == "allow", *sitemapFlag))
+	}
+
+	if *cname {
+		files = append(files, cnameFiles(vanity)...)
 	}
-	if err := os.MkdirAll(*outputDir, permDir); err != nil {
-		log.Fatalf("making directory %s: %s", *outputDir, err)
+
+	if *sitemapFlag {
+		existing := filepath.Join(*outputDir, vanity.host, sitemapName)
+		f, err := sitemapFile(vanity, existing, files, generated)
+		if err != nil {
+			fatalf("generating sitemap: %s", err)
+		}
+		files = append(files, f)
 	}
 
-	// Write output files.
+	if *platform != "" {
+		pfiles, err := platforms[*platform](vanity)
+		if err != nil {
+			fatalf("generating %s configuration: %s", *platform, err)
+		}
+		files = append(files, pfiles...)
+	}
+
+	switch *manifestFormat {
+	case "json":
+		f, err := manifestFile(files, generated, head)
+		if err != nil {
+			fatalf("generating manifest: %s", err)
+		}
+		files = append(files, f)
+	case "govanityurls":
+		files = append(files, govanityFile(files, vanity.host))
+	}
+
+	if isArchive(*outputDir) {
+		if err := writeArchive(*outputDir, files, generated); err != nil {
+			fatalf("writing archive %s: %s", *outputDir, err)
+		}
+	} else {
+		if *snapshotDir != "" {
+			if err := snapshot(*outputDir, *snapshotDir); err != nil {
+				fatalf("taking snapshot of %s: %s", *outputDir, err)
+			}
+		}
+		if err := writeFiles(*outputDir, files); err != nil {
+			fatalf("%s", err)
+		}
+	}
+	infof("wrote %d files to %s", len(files), *outputDir)
+
+	if dep != nil {
+		siteDir := filepath.Join(*outputDir, vanity.host)
+		if err := dep.deploy(siteDir); err != nil {
+			fatalf("deploying to %s: %s", *deployTarget, err)
+		}
+		infof("deployed %s to %s", siteDir, *deployTarget)
+	}
+
+	if *verify {
+		if err := checkProxy(baseImportPrefix); err != nil {
+			fatalf("verifying: %s", err)
+		}
+	}
+}
+
+// subdirWarned records the workspace modules warned about having
+// go-import tags with a subdirectory, so as to warn once per module.
+var subdirWarned = make(map[string]bool)
+
+// packagePages generates the page for each package directory of the
+// repository, served at importPrefix, and returns the pages and the sorted
+// import paths they are served at, by the go-import tag of their module,
+// which differs from args.GoImport for workspace modules. The pages link to the documentation at
+// the URL given by docURL, if it isn't nil. synopses holds the synopses of the
+// packages by directory, if any. args holds the tag values common to the
+// pages. With index, the page for the repository root lists the other
+// pages, and doesn't redirect. With canonical, the pages name their
+// canonical URLs.
+func packagePages(t *template.Template, docURL *texttemplate.Template, importPrefix string, dirs map[string]struct{}, synopses map[string]string, mods []module, args TemplateArgs, index, canonical bool) ([]File, map[GoImport][]string, error) {
+	var pages []TemplateArgs
+	for d := range dirs {
+		goImport := args.GoImport
+		fullImportPrefix := path.Join(importPrefix, d)
+		// A module proxy serves modules by module path, so with the mod
+		// VCS, every workspace module needs a tag of its own.
+		proxied := goImport.VCS == "mod"
+		if m, ok := containingModule(mods, d); ok && (m.path != path.Join(importPrefix, m.dir) || proxied && m.dir != ".") {
+			// The workspace module's path doesn't follow the repository
+			// layout, so the page needs a tag for the module itself,
+			// naming the subdirectory the module is in.
+			rel, _ := filepath.Rel(m.dir, d)
+			fullImportPrefix = path.Join(m.path, filepath.ToSlash(rel))
+			goImport = GoImport{ImportPrefix: m.path, VCS: goImport.VCS, RepoRoot: goImport.RepoRoot}
+			if m.dir != "." && !proxied {
+				goImport.Subdir = m.dir
+				if !subdirWarned[m.path] {
+					subdirWarned[m.path] = true
+					warnf("the go-import tag for %s names the subdirectory %s, which only Go 1.25 and later understand", m.path, m.dir)
+				}
+			}
+		}
+		args := args
+		args.GoImport = goImport
+		if docURL != nil {
+			u, err := redirectURL(docURL, fullImportPrefix)
+			if err != nil {
+				return nil, nil, fmt.Errorf("executing -redirect-url for %s: %s", fullImportPrefix, err)
+			}
+			args.GodocURL = u
+		}
+		args.ImportPath = fullImportPrefix
+		args.Dir = d
+		args.Synopsis = synopses[d]
+		if canonical {
+			args.CanonicalURL = "https://" + fullImportPrefix
+		}
+		pages = append(pages, args)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ImportPath < pages[j].ImportPath })
+
+	var files []File
+	packages := make(map[GoImport][]string)
+	for i := range pages {
+		args := pages[i]
+		if index && args.Dir == "." {
+			args.GodocRedirect = false
+			args.Packages = packageLinks(pages)
+		}
+		file := File{path: path.Join(args.ImportPath, "index.html"), page: &args}
+
+		if err := t.Execute(&file.contents, args); err != nil {
+			return nil, nil, fmt.Errorf("executing template for path %s: %s", file.path, err)
+		}
+		// Catch pages go get wouldn't understand.
+		if err := validatePage(args.ImportPath, file.contents.Bytes(), args.GoImport); err != nil {
+			return nil, nil, fmt.Errorf("validating page for %s: %s", args.ImportPath, err)
+		}
+		files = append(files, file)
+		packages[args.GoImport] = append(packages[args.GoImport], args.ImportPath)
+	}
+	return files, packages, nil
+}
+
+// packageLinks returns the links to the documentation and source of the
+// packages of the pages but the repository root's.
+func packageLinks(pages []TemplateArgs) []PackageLink {
+	var links []PackageLink
+	for _, p := range pages {
+		if p.Dir == "." {
+			continue
+		}
+		l := PackageLink{ImportPath: p.ImportPath, DocURL: p.GodocURL, Synopsis: p.Synopsis}
+		if p.GoSource != nil {
+			l.SourceURL = strings.NewReplacer("{/dir}", "/"+p.Dir, "{dir}", p.Dir).Replace(p.GoSource.Directory)
+		}
+		links = append(links, l)
+	}
+	return links
+}
+
+// writeFiles writes the files to the output directory dir, making it if
+// needed.
+func writeFiles(dir string, files []File) error {
+	if err := os.MkdirAll(dir, permDir); err != nil {
+		return fmt.Errorf("making directory %s: %s", dir, err)
+	}
 	for _, file := range files {
 		// This would fail if the repository had a structure like:
 		//   a/
@@ -171,15 +1069,186 @@ func main() {
 		//       b.go
 		// because we would need to have both 'a/index.html' (for the package at a)
 		// and 'a/index.html/index.html' (for package at a/index.html).
-		dir := filepath.Join(*outputDir, filepath.FromSlash(file.path))
-		if err := os.MkdirAll(dir, permDir); err != nil {
-			log.Fatalf("making directory %s: %s", dir, err)
+		f := filepath.Join(dir, filepath.FromSlash(file.path))
+		d := filepath.Dir(f)
+		if err := os.MkdirAll(d, permDir); err != nil {
+			return fmt.Errorf("making directory %s: %s", d, err)
 		}
-		f := filepath.Join(dir, "index.html")
 		if err := ioutil.WriteFile(f, file.contents.Bytes(), permFile); err != nil {
-			log.Fatalf("writing file %s: %s", f, err)
+			return fmt.Errorf("writing file %s: %s", f, err)
+		}
+		debugf("wrote %s", f)
+	}
+	return nil
+}
+
+// A File is a generated output file.
+type File struct {
+	path     string // slash-separated, relative to the output directory
+	contents bytes.Buffer
+	page     *TemplateArgs // the data of the page, for package pages
+}
+
+// site describes the generated site as a whole.
+type site struct {
+	host         string // vanity domain; the site is served at its root
+	importPrefix string // base import prefix
+	goImport     GoImport
+	goSource     *GoSource              // can be nil
+	redirect     bool                   // redirect browsers to the documentation
+	redirectURL  *texttemplate.Template // of the documentation; nil with -docsite none
+	packages     map[GoImport][]string  // import paths of the generated pages by go-import tag, sorted
+}
+
+func newSite(importPrefix string) site {
+	return site{
+		// The vanity domain is the first element of the import prefix.
+		host:         strings.SplitN(importPrefix, "/", 2)[0],
+		importPrefix: importPrefix,
+	}
+}
+
+// expandArgFiles replaces each argument of the form @file in args with the
+// arguments listed in file.
+func expandArgFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			expanded = append(expanded, arg)
+			continue
+		}
+		b, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields, err := splitArgs(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", arg[1:], i+1, err)
+			}
+			expanded = append(expanded, fields...)
+		}
+	}
+	return expanded, nil
+}
+
+// splitArgs splits line into arguments at whitespace, honoring shell-style
+// single and double quotes and backslash escapes.
+func splitArgs(line string) ([]string, error) {
+	var (
+		args  []string
+		arg   []rune
+		inArg bool // whether arg has begun, since it may be empty, as in ""
+		quote rune // the open quote, if any
+		esc   bool // whether the previous rune was an escaping backslash
+	)
+	for _, r := range line {
+		switch {
+		case esc:
+			// Inside double quotes, a backslash escapes only a double
+			// quote or backslash, and is kept before anything else.
+			if quote == '"' && r != '"' && r != '\\' {
+				arg = append(arg, '\\')
+			}
+			arg = append(arg, r)
+			esc = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				esc = true
+			default:
+				arg = append(arg, r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == '\\':
+			esc, inArg = true, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = arg[:0], false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if esc {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// normalizeRepoRoot returns the repository root to advertise in the
+// generated tags. The URL used for fetching is left as is; some hosts are
+// picky about the form of the root that go get later resolves.
+func normalizeRepoRoot(repoURL, gitSuffix string, trimSlash, forceHTTPS bool) (string, error) {
+	root := repoURL
+
+	// Azure DevOps repositories have several URLs; advertise the one it
+	// uses itself, without any user name, if it is an https one or -https
+	// is set.
+	if a, ok := parseAzureURL(root); ok {
+		if _, isSSH := parseSSHURL(root); !isSSH || forceHTTPS {
+			root = a.url()
+		}
+	}
+
+	if forceHTTPS {
+		if u, ok := sshHTTPSURL(root); ok {
+			root = u
+		}
+		u, err := url.Parse(root)
+		if err != nil {
+			return "", err
+		}
+		if u.Host == "" {
+			return "", fmt.Errorf("cannot use https scheme for %s: missing host", repoURL)
 		}
+		u.Scheme = "https"
+		u.User = nil
+		root = u.String()
+	}
+
+	if trimSlash {
+		root = strings.TrimRight(root, "/")
 	}
+
+	// Keep any remaining trailing slashes after the suffix.
+	trimmed := strings.TrimRight(root, "/")
+	slashes := root[len(trimmed):]
+
+	switch gitSuffix {
+	case "":
+	case "strip":
+		trimmed = strings.TrimSuffix(trimmed, ".git")
+	case "append":
+		if !strings.HasSuffix(trimmed, ".git") {
+			trimmed += ".git"
+		}
+	default:
+		return "", fmt.Errorf("invalid -git-suffix value %q", gitSuffix)
+	}
+
+	return trimmed + slashes, nil
 }
 
 // Notes
@@ -198,24 +1267,128 @@ func main() {
 // we can use the directory and file for godoc's tag only if the default branch was pulled.
 //   directory: https://bitbucket.org/multicores/hw3/src/HEAD/q5/queue
 //   file and line: https://bitbucket.org/multicores/hw3/src/HEAD/q5/queue/LockQueue.java?fileviewer=file-view-default#LockQueue.java-11
+//
+// GitLab's formats are like GitHub's, under the /-/ path.
+//   directory: https://gitlab.com/gitlab-org/cli/-/tree/main/some/directory
+//   file and line: https://gitlab.com/gitlab-org/cli/-/blob/main/some/directory/somefile#L42
+//
+// Gitea's, and Forgejo's, formats name the kind of ref before the ref:
+// branch, tag or commit.
+//   directory: https://codeberg.org/forgejo/forgejo/src/branch/forgejo/some/directory
+//   file and line: https://codeberg.org/forgejo/forgejo/src/tag/v1.0.0/some/directory/somefile#L42
+//
+// Gogs's formats, from which Gitea's derive, have only the name of the ref,
+// a branch, tag or commit.
+//   directory: https://gogs.example.org/user/repo/src/main/some/directory
+//   file and line: https://gogs.example.org/user/repo/src/main/some/directory/somefile#L42
+//
+// Gitiles, which serves *.googlesource.com and is Gerrit's browser, has the
+// full name of the ref, or the commit, after /+/. Gerrit serves it under
+// /plugins/gitiles/.
+//   directory: https://go.googlesource.com/tools/+/refs/heads/master/some/directory
+//   file and line: https://gerrit.example.org/plugins/gitiles/project/+/refs/tags/v1.0.0/some/directory/somefile#42
+//
+// cgit names the branch or tag in the h query parameter, and the commit in
+// the id one. Its repository URLs, which it also serves clones from, often
+// keep the .git suffix.
+//   directory: https://git.example.org/repo.git/tree/some/directory?h=main
+//   file and line: https://git.example.org/repo.git/tree/some/directory/somefile?id=0123abc#n42
+//
+// gitweb takes everything in the query, with the project being the path of
+// the repository. It is assumed to be served at /gitweb/ on the host of the
+// repository, as its packages install it.
+//   directory: https://git.example.org/gitweb/?p=repo.git;a=tree;f=some/directory;hb=refs/heads/main
+//   file and line: https://git.example.org/gitweb/?p=repo.git;a=blob;f=some/directory/somefile;hb=refs/heads/main#l42
+//
+// Bitbucket Server, or Data Center, shows repositories at paths other than
+// those it serves clones from, with the full name of the ref, or the
+// commit, in the at query parameter.
+//   directory: https://bitbucket.example.org/projects/PROJ/repos/repo/browse/some/directory?at=refs/heads/main
+//   file and line: https://bitbucket.example.org/projects/PROJ/repos/repo/browse/some/directory/somefile?at=refs/tags/v1.0.0#42
+//
+// Launchpad browses git repositories with cgit, at git.launchpad.net, and
+// Bazaar branches with Loggerhead, at bazaar.launchpad.net, where head:
+// names the tip of the branch.
+//   directory: https://bazaar.launchpad.net/~user/project/branch/files/head:/some/directory
+//   file and line: https://bazaar.launchpad.net/+branch/project/view/head:/some/directory/somefile#L42
+//
+// Azure DevOps shows files with the path and version query parameters, the
+// version being the branch, tag or commit prefixed with GB, GT or GC. The
+// default branch is shown without a version.
+//   directory: https://dev.azure.com/org/project/_git/repo?path=/some/directory&version=GBsome-branch
+//   file and line: https://dev.azure.com/org/project/_git/repo?path=/somefile&line=42&lineEnd=42&lineStartColumn=1&lineEndColumn=1
+//
+// AWS CodeCommit has no pages of its own; the AWS console shows the
+// repositories, with the full name of the ref, or the commit, in the path.
+//   directory: https://us-east-1.console.aws.amazon.com/codesuite/codecommit/repositories/repo/browse/refs/heads/main/--/some/directory?region=us-east-1
+//   file and line: https://us-east-1.console.aws.amazon.com/codesuite/codecommit/repositories/repo/browse/refs/tags/v1.0.0/--/somefile?region=us-east-1&lines=42-42
 
 func shortBranch(long string) string {
 	return strings.TrimPrefix(long, "refs/heads/")
 }
 
-func determineGodocSpec(repoURL, requestedBranch string, usedDefaultBranch bool, repo *git.Repository) GodocSpec {
+// sourceHomeURL, sourceDirURL and sourceFileURL are the go-source formats
+// given by -source-home, -source-dir and -source-file, if any, which take
+// the place of those determined for the host.
+var sourceHomeURL, sourceDirURL, sourceFileURL string
+
+// determineGodocSpec returns the go-source formats for the repository, those
+// given by the flags taking the place of those of the host.
+func determineGodocSpec(repoURL, sourceHost string, ref treeRef, backend vcsBackend) GodocSpec {
+	spec := hostGodocSpec(repoURL, sourceHost, ref, backend)
+	if sourceHomeURL == "" && sourceDirURL == "" && sourceFileURL == "" {
+		return spec
+	}
+	return Custom{spec, sourceHomeURL, sourceDirURL, sourceFileURL}
+}
+
+// hostGodocSpec returns the go-source formats of the host of the
+// repository. The kind of software hosting it is given by sourceHost, if
+// set, or otherwise detected from its host.
+func hostGodocSpec(repoURL, sourceHost string, ref treeRef, backend vcsBackend) GodocSpec {
+	if a, ok := parseAzureURL(repoURL); ok {
+		if def, ok := defaultBranch(backend); ok && ref == (treeRef{}) {
+			ref.branch = def
+		}
+		return AzureDevOps{a.url(), azureVersion(ref)}
+	}
+	if c, ok := parseCodeCommitURL(repoURL); ok {
+		def, ok := defaultBranch(backend)
+		if !ok && ref == (treeRef{}) {
+			return Default{c.consoleURL()}
+		}
+		return CodeCommit{c.consoleURL(), ref.fullName(def), c.region}
+	}
+	// Launchpad's Bazaar branches are browsed with Loggerhead, which links
+	// to the files at the branch's tip only.
+	if b, ok := launchpadBranch(repoURL); ok {
+		if ref != (treeRef{}) {
+			return Default{repoURL}
+		}
+		return Loggerhead{loggerheadURL(b)}
+	}
+	// Hosts serve repositories over SSH and https alike, and the https
+	// URL, without the .git suffix but for cgit and gitweb, is the one to
+	// browse.
+	if u, ok := sshHTTPSURL(repoURL); ok {
+		repoURL = u
+		if sourceHost != "cgit" && sourceHost != "gitweb" {
+			repoURL = strings.TrimSuffix(u, ".git")
+		}
+	}
+	def, ok := defaultBranch(backend)
+	if !ok && ref == (treeRef{}) {
+		return Default{repoURL}
+	}
 	if u, err := url.Parse(repoURL); err == nil {
-		switch u.Host {
-		case "github.com":
-			b := requestedBranch
-			if usedDefaultBranch {
-				b = shortBranch(repo.Remotes[git.DefaultRemoteName].DefaultBranch())
-			}
-			return GitHub{repoURL, b}
-		case "bitbucket.org":
-			if usedDefaultBranch || shortBranch(repo.Remotes[git.DefaultRemoteName].DefaultBranch()) == requestedBranch {
-				return BitBucket{repoURL}
-			}
+		if sourceHost == "" {
+			sourceHost = sourceHosts[u.Host]
+		}
+		if sourceHost == "" && strings.HasSuffix(u.Host, ".googlesource.com") {
+			sourceHost = "gitiles"
+		}
+		if kind, ok := sourceKinds[sourceHost]; ok {
+			return kind(repoURL, ref, def)
 		}
 	}
 	return Default{repoURL}
@@ -238,6 +1411,85 @@ func (g GitHub) file() string {
 	return fmt.Sprintf("%s/tree/%s{/dir}/{file}#L{line}", g.repoURL, g.branch)
 }
 
+type GitLab struct {
+	repoURL string
+	branch  string
+}
+
+func (g GitLab) home() string      { return "_" }
+func (g GitLab) directory() string { return fmt.Sprintf("%s/-/tree/%s{/dir}", g.repoURL, g.branch) }
+func (g GitLab) file() string {
+	return fmt.Sprintf("%s/-/blob/%s{/dir}/{file}#L{line}", g.repoURL, g.branch)
+}
+
+type Gitea struct {
+	repoURL string
+	ref     string // the kind of ref and its name, as in branch/main
+}
+
+func (g Gitea) home() string      { return "_" }
+func (g Gitea) directory() string { return fmt.Sprintf("%s/src/%s{/dir}", g.repoURL, g.ref) }
+func (g Gitea) file() string {
+	return fmt.Sprintf("%s/src/%s{/dir}/{file}#L{line}", g.repoURL, g.ref)
+}
+
+type Gogs struct {
+	repoURL string
+	ref     string
+}
+
+func (g Gogs) home() string      { return "_" }
+func (g Gogs) directory() string { return fmt.Sprintf("%s/src/%s{/dir}", g.repoURL, g.ref) }
+func (g Gogs) file() string {
+	return fmt.Sprintf("%s/src/%s{/dir}/{file}#L{line}", g.repoURL, g.ref)
+}
+
+type Gitiles struct {
+	repoURL string
+	ref     string // as in refs/heads/main
+}
+
+func (g Gitiles) home() string      { return "_" }
+func (g Gitiles) directory() string { return fmt.Sprintf("%s/+/%s{/dir}", g.repoURL, g.ref) }
+func (g Gitiles) file() string {
+	return fmt.Sprintf("%s/+/%s{/dir}/{file}#{line}", g.repoURL, g.ref)
+}
+
+type Cgit struct {
+	repoURL string
+	query   string // naming the ref, as in h=main, or the commit, as in id=…
+}
+
+func (c Cgit) home() string      { return "_" }
+func (c Cgit) directory() string { return fmt.Sprintf("%s/tree{/dir}?%s", c.repoURL, c.query) }
+func (c Cgit) file() string {
+	return fmt.Sprintf("%s/tree{/dir}/{file}?%s#n{line}", c.repoURL, c.query)
+}
+
+type Gitweb struct {
+	gitwebURL string
+	project   string
+	hash      string // the full name of the ref, or the commit
+}
+
+func (g Gitweb) home() string {
+	return fmt.Sprintf("%s?p=%s;a=summary", g.gitwebURL, g.project)
+}
+func (g Gitweb) directory() string {
+	return fmt.Sprintf("%s?p=%s;a=tree;f={dir};hb=%s", g.gitwebURL, g.project, g.hash)
+}
+func (g Gitweb) file() string {
+	return fmt.Sprintf("%s?p=%s;a=blob;f={dir}/{file};hb=%s#l{line}", g.gitwebURL, g.project, g.hash)
+}
+
+type Loggerhead struct {
+	branchURL string
+}
+
+func (l Loggerhead) home() string      { return l.branchURL }
+func (l Loggerhead) directory() string { return l.branchURL + "/files/head:{/dir}" }
+func (l Loggerhead) file() string      { return l.branchURL + "/view/head:{/dir}/{file}#L{line}" }
+
 type BitBucket struct {
 	repoURL string
 }
@@ -248,6 +1500,70 @@ func (b BitBucket) file() string {
 	return fmt.Sprintf("%s/src/HEAD{/dir}/{file}?fileviewer=file-view-default#{file}-{line}", b.repoURL)
 }
 
+type BitbucketServer struct {
+	repoURL string // of the repository's pages
+	at      string // the full name of the ref, or the commit
+}
+
+func (b BitbucketServer) home() string { return b.repoURL }
+func (b BitbucketServer) directory() string {
+	return fmt.Sprintf("%s/browse{/dir}?at=%s", b.repoURL, b.at)
+}
+func (b BitbucketServer) file() string {
+	return fmt.Sprintf("%s/browse{/dir}/{file}?at=%s#{line}", b.repoURL, b.at)
+}
+
+type AzureDevOps struct {
+	repoURL string
+	version string
+}
+
+func (a AzureDevOps) query() string {
+	if a.version == "" {
+		return ""
+	}
+	return "&version=" + a.version
+}
+
+func (a AzureDevOps) home() string      { return a.repoURL }
+func (a AzureDevOps) directory() string { return a.repoURL + "?path={/dir}" + a.query() }
+func (a AzureDevOps) file() string {
+	return a.repoURL + "?path={/dir}/{file}" + a.query() + "&line={line}&lineEnd={line}&lineStartColumn=1&lineEndColumn=1"
+}
+
+type CodeCommit struct {
+	consoleURL string
+	ref        string // as in refs/heads/main
+	region     string
+}
+
+func (c CodeCommit) home() string { return c.consoleURL + "?region=" + c.region }
+func (c CodeCommit) directory() string {
+	return fmt.Sprintf("%s/browse/%s/--{/dir}?region=%s", c.consoleURL, c.ref, c.region)
+}
+func (c CodeCommit) file() string {
+	return fmt.Sprintf("%s/browse/%s/--{/dir}/{file}?region=%s&lines={line}-{line}", c.consoleURL, c.ref, c.region)
+}
+
+// Custom is the go-source formats given by flags, each falling back to that
+// of the host if not given.
+type Custom struct {
+	host                     GodocSpec
+	homeURL, dirURL, fileURL string
+}
+
+func (c Custom) home() string      { return or(c.homeURL, c.host.home()) }
+func (c Custom) directory() string { return or(c.dirURL, c.host.directory()) }
+func (c Custom) file() string      { return or(c.fileURL, c.host.file()) }
+
+// or returns s, or def if s is empty.
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
 type Default struct {
 	repoURL string
 }
@@ -259,18 +1575,37 @@ func (d Default) file() string      { return d.repoURL }
 const tmpl = `<!DOCTYPE html>
 <html>
 	<head>
-		<meta charset="utf-8">
-		{{ with .GoImport }}<meta name="go-import" content="{{ .ImportPrefix }} {{ .VCS }} {{ .RepoRoot }}">{{ end }}
+		<meta charset="utf-8">{{ with .Synopsis }}
+		<meta name="description" content="{{ . }}">{{ end }}{{ with .CanonicalURL }}
+		<link rel="canonical" href="{{ . }}">{{ end }}
+		{{ with .GoImport }}<meta name="go-import" content="{{ .ImportPrefix }} {{ .VCS }} {{ .RepoRoot }}{{ with .Subdir }} {{ . }}{{ end }}">{{ end }}
 		{{ with .GoSource }}<meta name="go-source" content="{{ .Prefix }} {{ .Home }} {{ .Directory }} {{ .File }}">{{ end }}
-		{{ if .GodocRedirect }}<meta http-equiv="refresh" content="0; url='{{ .GodocURL }}'">{{ end }}
+		{{ if .GodocRedirect }}{{ if .RedirectJS -}}
+		<script>
+			if (!/[?&]go-get=1(&|$)/.test(location.search) && location.hash !== "#no-redirect") {
+				location.replace({{ .GodocURL }});
+			}
+		</script>
+		{{- else }}<meta http-equiv="refresh" content="0; url='{{ .GodocURL }}'">{{ end }}{{ end }}{{ with .HeadInclude }}
+		{{ . }}{{ end }}
 	</head>
 	<body>
-		{{ if .GodocRedirect -}}
+		{{ with .Synopsis }}<p>{{ . }}</p>
+		{{ end }}{{ if .GodocRedirect -}}
 		Redirecting to <a href="{{ .GodocURL }}">{{ .GodocURL }}</a>
 		{{- else -}}
 		Repository: <a href="{{ .GoImport.RepoRoot }}">{{ .GoImport.RepoRoot }}</a>
+		{{- with .GodocURL }}
 		<br>
-		Godoc: <a href="{{ .GodocURL }}">{{ .GodocURL }}</a>
+		Godoc: <a href="{{ . }}">{{ . }}</a>
+		{{- end }}
+		{{- with .Packages }}
+		<ul>
+			{{- range . }}
+			<li>{{ if .DocURL }}<a href="{{ .DocURL }}">{{ .ImportPath }}</a>{{ else }}{{ .ImportPath }}{{ end }}{{ with .SourceURL }} (<a href="{{ . }}">source</a>){{ end }}{{ with .Synopsis }}: {{ . }}{{ end }}</li>
+			{{- end }}
+		</ul>
+		{{- end }}
 		{{- end }}
 	</body>
 </html>
@@ -280,11 +1615,30 @@ type TemplateArgs struct {
 	GoImport      GoImport
 	GoSource      *GoSource
 	GodocRedirect bool
-	GodocURL      string
+	RedirectJS    bool          // redirect using JavaScript instead of <meta http-equiv="refresh">
+	GodocURL      string        // empty with -docsite none
+	ImportPath    string        // of the package the page is for
+	Dir           string        // of the package, slash-separated, relative to the repository root
+	Branch        string        // the pages are generated from; empty for a tag or revision
+	Revision      string        // the pages are generated from
+	Generated     time.Time     // when the pages were generated, in UTC
+	Packages      []PackageLink // with -index, on the page for the repository root
+	Synopsis      string        // of the package, with -synopsis
+	CanonicalURL  string        // of the page, with -canonical
+	HeadInclude   template.HTML // contents of the file of -head-include
+}
+
+// A PackageLink links to the documentation and source of a package.
+type PackageLink struct {
+	ImportPath string
+	DocURL     string // empty with -docsite none
+	SourceURL  string // empty without -godoc
+	Synopsis   string // empty without -synopsis
 }
 
 type GoImport struct {
 	ImportPrefix, VCS, RepoRoot string
+	Subdir                      string // optional subdirectory of the module in the repository
 }
 
 type GoSource struct {
@@ -294,37 +1648,114 @@ type GoSource struct {
 	File      string
 }
 
-func packageDirs(tree *git.Tree) (map[string]struct{}, error) {
-	iter := tree.Files()
-	defer iter.Close()
-	dirs := make(map[string]struct{})
+// dirFilter specifies which directories are considered during package
+// discovery. By default, these are the directories the go tool considers.
+type dirFilter struct {
+	dot        bool // include directories beginning with "."
+	underscore bool // include directories beginning with "_"
+	testdata   bool // include directories named "testdata"
 
-	for {
-		f, err := iter.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, fmt.Errorf("getting next file in tree: %s", err)
+	skipGenerated bool // skip directories containing only generated Go files
+	constraints   bool // skip Go files excluded by build constraints on all common platforms
+}
+
+// ignored reports whether the directory d, a slash-separated path
+// relative to the repository root, should be skipped.
+func (df dirFilter) ignored(d string) bool {
+	if d == "." {
+		return false
+	}
+	// 'go help packages' says:
+	//   Directory and file names that begin with "." or "_" are ignored
+	//   by the go tool, as are directories named "testdata".
+	for _, elem := range strings.Split(d, "/") {
+		switch {
+		case strings.HasPrefix(elem, ".") && !df.dot:
+			return true
+		case strings.HasPrefix(elem, "_") && !df.underscore:
+			return true
+		case elem == "testdata" && !df.testdata:
+			return true
 		}
-		// 'go help packages' says:
-		//   Directory and file names that begin with "." or "_" are ignored
-		//   by the go tool, as are directories named "testdata".
-		d := filepath.Dir(f.Name)
-		if filepath.Base(d) == "testdata" {
+	}
+	return false
+}
+
+func packageDirs(tree sourceTree, filter dirFilter) (map[string]struct{}, error) {
+	files, err := tree.files()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]struct{})
+	generated := make(map[string]bool) // whether all Go files in the directory are generated
+
+	for _, f := range files {
+		d, name := path.Split(f.name)
+		d = path.Clean(d)
+		if filter.ignored(d) {
+			debugf("skipping %s: directory ignored", f.name)
 			continue
 		}
-		if strings.HasPrefix(f.Name, ".") || strings.HasPrefix(f.Name, "_") || !strings.HasSuffix(f.Name, ".go") {
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || !strings.HasSuffix(name, ".go") {
 			// if it's not a go file we can't add the file's directory
 			// to dirs, so move on.
 			continue
 		}
+		if filter.constraints {
+			ok, err := matchesCommonPlatform(f)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %s", f.name, err)
+			}
+			if !ok {
+				debugf("skipping %s: excluded by build constraints", f.name)
+				continue
+			}
+		}
+		if filter.skipGenerated {
+			g, err := isGenerated(f)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %s", f.name, err)
+			}
+			if prev, ok := generated[d]; !ok || prev {
+				generated[d] = g
+			}
+		}
 		if _, ok := dirs[d]; ok {
 			// already accounted for
 			continue
 		}
+		debugf("found package directory %s", d)
 		dirs[d] = struct{}{}
 	}
 
+	for d, g := range generated {
+		if g {
+			debugf("skipping package directory %s: generated", d)
+			delete(dirs, d)
+		}
+	}
 	return dirs, nil
 }
+
+// generatedRe matches the comment marking generated Go files. See
+// https://golang.org/s/generatedcode.
+var generatedRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether the Go file f is generated, that is, whether
+// it has the generated code comment before its package clause.
+func isGenerated(f sourceFile) (bool, error) {
+	lines, err := f.lines()
+	if err != nil {
+		return false, err
+	}
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if generatedRe.MatchString(line) {
+			return true, nil
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`-https example.org/r https://github.com/user/r`, []string{"-https", "example.org/r", "https://github.com/user/r"}},
+		{`-git-header "Authorization: Bearer $TOKEN"`, []string{"-git-header", "Authorization: Bearer $TOKEN"}},
+		{`-redirect-url 'https://pkg.go.dev/{{ .ImportPath }}'`, []string{"-redirect-url", "https://pkg.go.dev/{{ .ImportPath }}"}},
+		{`"say \"hi\" \\ \n"`, []string{`say "hi" \ \n`}},
+		{`a\ b 'c\d' ""`, []string{"a b", `c\d`, ""}},
+		{`x"y z"w`, []string{"xy zw"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q): %s", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{`"open`, `'open`, `trailing\`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("splitArgs(%q): got no error", line)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// mirrorList is a flag.Value for the repeatable -mirror flag, whose values
+// are URLs of mirrors of the repository.
+type mirrorList []string
+
+func (m *mirrorList) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *mirrorList) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
+
+// openTree fetches the tree named by ref from the first of the repository
+// URLs, tried in order, that can be fetched, and returns its backend along
+// with the tree and its revision. A failed fetch is retried fetchRetries
+// times, after a growing delay, before the next URL is tried. The error of
+// the last URL is returned if none can be fetched.
+func openTree(newBackend func(repoURL string) (vcsBackend, error), urls []string, ref treeRef) (vcsBackend, sourceTree, string, error) {
+	var err error
+	for i, u := range urls {
+		if i > 0 {
+			warnf("%s; trying mirror %s", err, u)
+		}
+		delay := time.Second
+		for attempt := 0; ; attempt++ {
+			var backend vcsBackend
+			var tree sourceTree
+			var head string
+			if backend, err = newBackend(u); err == nil {
+				if tree, head, err = backend.tree(ref); err == nil {
+					return backend, tree, head, nil
+				}
+				backend.close()
+			}
+			if attempt == fetchRetries {
+				break
+			}
+			warnf("%s; retrying in %s", err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return nil, nil, "", err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// notFoundName is the name of the page static hosts serve for missing
+// paths, at the root of the site.
+const notFoundName = "404.html"
+
+var notFoundTmpl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		{{- range . }}
+		<meta name="go-import" content="{{ .ImportPrefix }} {{ .VCS }} {{ .RepoRoot }}{{ with .Subdir }} {{ . }}{{ end }}">
+		{{- end }}
+	</head>
+	<body>
+		Not found
+	</body>
+</html>
+`))
+
+// notFoundFile returns the 404.html for the site, with the go-import tags
+// of the package pages among files, so that go get resolves the import
+// paths without pages of their own, such as those of packages added since
+// the site was generated. go get reads the tags of pages served with any
+// status. The tags of other import prefixes are kept from the page
+// previously generated at existing, if any. Since go get fails if several
+// tags match, the tags of import prefixes under others are left out with a
+// warning.
+func notFoundFile(s site, existing string, files []File) (File, error) {
+	tags := make(map[string]GoImport)
+	for _, f := range files {
+		if f.page != nil && strings.HasPrefix(f.path, s.host+"/") {
+			tags[f.page.GoImport.ImportPrefix] = f.page.GoImport
+		}
+	}
+
+	b, err := ioutil.ReadFile(existing)
+	switch {
+	case err == nil:
+		old, err := parseMetaGoImports(strings.NewReader(string(b)))
+		if err != nil {
+			return File{}, err
+		}
+		for _, m := range old {
+			if _, ok := tags[m.ImportPrefix]; !ok && m.ImportPrefix != s.importPrefix {
+				tags[m.ImportPrefix] = m
+			}
+		}
+	case !os.IsNotExist(err):
+		return File{}, err
+	}
+
+	var prefixes []string
+	for p := range tags {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	var imports []GoImport
+	for _, p := range prefixes {
+		if n := len(imports); n > 0 && strings.HasPrefix(p, imports[n-1].ImportPrefix+"/") {
+			warnf("leaving the go-import tag of %s, under %s, out of %s", p, imports[n-1].ImportPrefix, notFoundName)
+			continue
+		}
+		imports = append(imports, tags[p])
+	}
+
+	f := File{path: path.Join(s.host, notFoundName)}
+	err = notFoundTmpl.Execute(&f.contents, imports)
+	return f, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	git "gopkg.in/src-d/go-git.v3"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	gitcore "gopkg.in/src-d/go-git.v3/core"
+)
+
+// treeOnly is set by -tree-only. The trees of the git repository are then
+// fetched without the blobs, and only the files that are read, such as
+// go.mod files, are fetched later, as git does for partial clones.
+var treeOnly bool
+
+// treeRequests are the requests that ask for no blobs, with the filter
+// capability, and blobRequests are those for the blobs of the files read
+// from the trees they fetched. Other requests are encoded as usual.
+var (
+	treeRequests = make(map[*common.GitUploadPackRequest]bool)
+	blobRequests = make(map[*common.GitUploadPackRequest]bool)
+)
+
+// canFetchTreesOnly reports whether the remote of the repository at
+// repoURL, with the capabilities, can send trees without their blobs, and
+// the blobs later. Over SSH, git-upload-pack only sends objects named by
+// refs unless configured otherwise; over http, and from the cache, it is
+// stateless and sends any reachable object.
+func canFetchTreesOnly(caps *common.Capabilities, repoURL string) bool {
+	if !caps.Supports("filter") {
+		return false
+	}
+	if _, ok := parseSSHURL(repoURL); ok && cacheDir == "" {
+		return caps.Supports("allow-reachable-sha1-in-want") || caps.Supports("allow-any-sha1-in-want")
+	}
+	return true
+}
+
+// fetchBlobs fetches the blobs from the default remote of the repository,
+// which must be connected, into its storage.
+func fetchBlobs(repo *git.Repository, blobs []gitcore.Hash) error {
+	debugf("fetching %d blobs", len(blobs))
+	req := &common.GitUploadPackRequest{}
+	for _, h := range blobs {
+		req.Want(h)
+	}
+	blobRequests[req] = true
+	defer delete(blobRequests, req)
+	rc, err := repo.Remotes[git.DefaultRemoteName].Fetch(req)
+	if err != nil {
+		return fmt.Errorf("fetching blobs: %s", err)
+	}
+	defer rc.Close()
+	if err := decodePack(rc, repo.Storage); err != nil {
+		return fmt.Errorf("fetching blobs: %s", err)
+	}
+	return nil
+}
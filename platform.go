@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// platforms maps the hosting platforms supported by -platform to functions
+// that generate their configuration files.
+var platforms = map[string]func(site) ([]File, error){
+	"azure":   azureFiles,
+	"fastly":  fastlyFiles,
+	"haproxy": haproxyFiles,
+	"vercel":  vercelFiles,
+}
+
+// sitePath returns the absolute URL path on the vanity domain for the
+// import path p.
+func (s site) sitePath(p string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(p, s.host), "/")
+}
+
+// jsonFile returns a File at path p containing v encoded as indented JSON.
+func jsonFile(p string, v interface{}) (File, error) {
+	f := File{path: p}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return f, err
+	}
+	f.contents.Write(b)
+	f.contents.WriteByte('\n')
+	return f, nil
+}
+
+// azureFiles returns the staticwebapp.config.json for Azure Static Web Apps.
+// See https://learn.microsoft.com/en-us/azure/static-web-apps/configuration.
+func azureFiles(s site) ([]File, error) {
+	root := s.sitePath(s.importPrefix)
+	config := map[string]interface{}{
+		// Serve a/index.html at a, without a redirect to a/.
+		"trailingSlash": "auto",
+		// go get of a path under the prefix that has no page of its own
+		// gets the page for the prefix, whose go-import tag covers it.
+		"navigationFallback": map[string]interface{}{
+			"rewrite": path.Join(root, "index.html"),
+		},
+		"routes": []map[string]interface{}{{
+			"route": path.Join(root, "*"),
+			"headers": map[string]string{
+				// Keep caches from serving stale tags for long after
+				// the site is regenerated.
+				"Cache-Control": "public, max-age=300",
+			},
+		}},
+		"mimeTypes": map[string]string{
+			".html": "text/html; charset=utf-8",
+		},
+	}
+	f, err := jsonFile(path.Join(s.host, "staticwebapp.config.json"), config)
+	if err != nil {
+		return nil, err
+	}
+	return []File{f}, nil
+}
+
+// vercelFiles returns the vercel.json for Vercel, which serves the site as
+// static files. See https://vercel.com/docs/project-configuration.
+func vercelFiles(s site) ([]File, error) {
+	root := s.sitePath(s.importPrefix)
+	config := map[string]interface{}{
+		// Serve a/index.html at a, without a redirect to a/.
+		"trailingSlash": false,
+		// go get of a path under the prefix that has no page of its own
+		// gets the page for the prefix, whose go-import tag covers it.
+		// Vercel serves the files that exist before applying rewrites.
+		"rewrites": []map[string]string{{
+			"source":      path.Join(root, ":path*"),
+			"destination": path.Join(root, "index.html"),
+		}},
+		"headers": []map[string]interface{}{{
+			"source": path.Join(root, "(.*)"),
+			"headers": []map[string]string{{
+				// Keep caches from serving stale tags for long after
+				// the site is regenerated.
+				"key":   "Cache-Control",
+				"value": "public, max-age=300",
+			}},
+		}},
+	}
+	f, err := jsonFile(path.Join(s.host, "vercel.json"), config)
+	if err != nil {
+		return nil, err
+	}
+	return []File{f}, nil
+}
+
+// fastlyFiles returns the source of a Fastly Compute service, built with
+// the Go SDK, that serves the pages for the import prefix at the edge.
+// See https://www.fastly.com/documentation/guides/compute/go/.
+func fastlyFiles(s site) ([]File, error) {
+	files := []File{
+		{path: "fastly/fastly.toml"},
+		{path: "fastly/go.mod"},
+		{path: "fastly/main.go"},
+	}
+	args := fastlyArgs{Host: s.host, GoImport: s.goImport, Redirect: s.redirect}
+	if s.redirectURL != nil {
+		var err error
+		if args.DocURLBefore, args.DocURLAfter, err = redirectURLParts(s.redirectURL); err != nil {
+			return nil, err
+		}
+	}
+	if g := s.goSource; g != nil {
+		args.GoSource = strings.Join([]string{g.Prefix, g.Home, g.Directory, g.File}, " ")
+	}
+	for i, t := range []*template.Template{fastlyTOMLTmpl, fastlyGoModTmpl, fastlyMainTmpl} {
+		if err := t.Execute(&files[i].contents, args); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// fastlyArgs is the data for the Fastly templates.
+type fastlyArgs struct {
+	Host     string
+	GoImport GoImport
+	GoSource string // content of the go-source tag, if any
+	Redirect bool
+
+	// The URL of the documentation is DocURLBefore, the import path, and
+	// DocURLAfter. Both are empty with -docsite none.
+	DocURLBefore, DocURLAfter string
+}
+
+var fastlyTOMLTmpl = template.Must(template.New("").Parse(`# Generated by metaimport.
+
+manifest_version = 3
+name = "{{ .Host }}"
+description = "go-import meta tags for {{ .Host }}"
+language = "go"
+
+[scripts]
+  build = "go build -o bin/main.wasm ."
+  env_vars = ["GOARCH=wasm", "GOOS=wasip1"]
+  post_init = "go mod tidy"
+`))
+
+var fastlyGoModTmpl = template.Must(template.New("").Parse(`module {{ .Host }}/fastly
+
+go 1.21
+`))
+
+var fastlyMainTmpl = template.Must(template.New("").Parse(`// Code generated by metaimport. DO NOT EDIT.
+
+// Command fastly is a Fastly Compute service that serves the go-import
+// meta tags for {{ .Host }}. Build and deploy it with
+//
+//	go mod tidy
+//	fastly compute publish
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/fastly/compute-sdk-go/fsthttp"
+)
+
+type module struct {
+	prefix, vcs, repoRoot string
+	goSource              string // content of the go-source tag, if any
+}
+
+// modules lists the import prefixes served and their repositories.
+var modules = []module{
+{{- with .GoImport }}
+	{ {{- printf "%q" .ImportPrefix }}, {{ printf "%q" .VCS }}, {{ printf "%q" .RepoRoot }}, {{ printf "%q" $.GoSource }}},
+{{- end }}
+}
+
+const (
+	host     = {{ printf "%q" .Host }}
+	redirect = {{ .Redirect }} // redirect browsers to the documentation
+
+	docURLBefore = {{ printf "%q" .DocURLBefore }}
+	docURLAfter  = {{ printf "%q" .DocURLAfter }}
+)
+
+func main() {
+	fsthttp.ServeFunc(func(ctx context.Context, w fsthttp.ResponseWriter, r *fsthttp.Request) {
+		importPath := strings.TrimSuffix(host+r.URL.Path, "/")
+		for _, m := range modules {
+			if importPath == m.prefix || strings.HasPrefix(importPath, m.prefix+"/") {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("Cache-Control", "public, max-age=300")
+				w.WriteHeader(fsthttp.StatusOK)
+				fmt.Fprint(w, page(m, importPath))
+				return
+			}
+		}
+		w.WriteHeader(fsthttp.StatusNotFound)
+		fmt.Fprintln(w, "not found")
+	})
+}
+
+func page(m module, importPath string) string {
+	e := html.EscapeString
+	var godocURL string
+	if docURLBefore != "" {
+		godocURL = docURLBefore + importPath + docURLAfter
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<meta name=\"go-import\" content=\"%s %s %s\">\n", e(m.prefix), e(m.vcs), e(m.repoRoot))
+	if m.goSource != "" {
+		fmt.Fprintf(&b, "<meta name=\"go-source\" content=\"%s\">\n", e(m.goSource))
+	}
+	if redirect {
+		fmt.Fprintf(&b, "<meta http-equiv=\"refresh\" content=\"0; url='%s'\">\n", e(godocURL))
+	}
+	b.WriteString("</head>\n<body>\n")
+	if redirect {
+		fmt.Fprintf(&b, "Redirecting to <a href=\"%s\">%s</a>\n", e(godocURL), e(godocURL))
+	} else {
+		fmt.Fprintf(&b, "Repository: <a href=\"%s\">%s</a>\n", e(m.repoRoot), e(m.repoRoot))
+		if godocURL != "" {
+			fmt.Fprintf(&b, "<br>\nGodoc: <a href=\"%s\">%s</a>\n", e(godocURL), e(godocURL))
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+`))
+
+// haproxyFiles returns a HAProxy map file from request paths to go-import
+// tag contents, and a configuration snippet that uses the map to serve the
+// tags directly from HAProxy.
+func haproxyFiles(s site) ([]File, error) {
+	const mapFile = "/etc/haproxy/metaimport.map"
+
+	m := File{path: "haproxy/metaimport.map"}
+	fmt.Fprintf(&m.contents, "# Generated by metaimport. Install as %s.\n", mapFile)
+	// The key matches the path of the import prefix and every path under
+	// it, since the go-import tag for the prefix covers them all.
+	root := s.sitePath(s.importPrefix)
+	key := "^" + regexp.QuoteMeta(strings.TrimSuffix(root, "/")) + "(/.*)?$"
+	g := s.goImport
+	fmt.Fprintf(&m.contents, "%s %s %s %s\n", key, g.ImportPrefix, g.VCS, g.RepoRoot)
+
+	head := `<meta charset="utf-8"><meta name="go-import" content="%[var(txn.goimport)]">`
+	if g := s.goSource; g != nil {
+		content := strings.Join([]string{g.Prefix, g.Home, g.Directory, g.File}, " ")
+		head += `<meta name="go-source" content="` + haproxyEscape(html.EscapeString(content)) + `">`
+	}
+	if s.redirect {
+		before, after, err := redirectURLParts(s.redirectURL)
+		if err != nil {
+			return nil, err
+		}
+		e := func(s string) string { return haproxyEscape(html.EscapeString(s)) }
+		head += `<meta http-equiv="refresh" content="0; url='` + e(before+s.host) + `%[path]` + e(after) + `'">`
+	}
+	page := "<!DOCTYPE html><html><head>" + head + "</head><body></body></html>"
+
+	c := File{path: "haproxy/metaimport.cfg"}
+	fmt.Fprintf(&c.contents, "# Generated by metaimport. Add to the frontend serving %s.\n", s.host)
+	fmt.Fprintf(&c.contents, "http-request set-var(txn.goimport) path,map_reg(%s) if { hdr(host) -i %s }\n", mapFile, s.host)
+	fmt.Fprintf(&c.contents, "http-request return status 200 content-type \"text/html; charset=utf-8\" lf-string \"%s\" if { var(txn.goimport) -m found }\n",
+		strings.Replace(page, `"`, `\"`, -1))
+
+	return []File{m, c}, nil
+}
+
+// haproxyEscape escapes s for use as literal text in a HAProxy log-format
+// string.
+func haproxyEscape(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+)
+
+// profiling configures the profiles to capture, from the -cpuprofile,
+// -memprofile and -trace flags. Empty file names disable the profile.
+type profiling struct {
+	cpu, mem, trace string
+}
+
+// start starts the CPU profile and execution trace, if enabled. The
+// returned function stops them and writes the heap profile; it must be
+// called for the profiles to be complete. Calls after the first do nothing.
+func (p profiling) start() (stop func() error, err error) {
+	var cpuFile, traceFile *os.File
+	if p.cpu != "" {
+		cpuFile, err = os.Create(p.cpu)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("starting CPU profile: %s", err)
+		}
+	}
+	if p.trace != "" {
+		traceFile, err = os.Create(p.trace)
+		if err != nil {
+			return nil, err
+		}
+		if err := trace.Start(traceFile); err != nil {
+			traceFile.Close()
+			return nil, fmt.Errorf("starting trace: %s", err)
+		}
+	}
+
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() { err = p.stop(cpuFile, traceFile) })
+		return err
+	}, nil
+}
+
+// stop stops the CPU profile and execution trace writing to the files, if
+// any, and writes the heap profile, if enabled.
+func (p profiling) stop(cpuFile, traceFile *os.File) error {
+	if cpuFile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuFile.Close(); err != nil {
+			return err
+		}
+	}
+	if traceFile != nil {
+		trace.Stop()
+		if err := traceFile.Close(); err != nil {
+			return err
+		}
+	}
+	if p.mem != "" {
+		f, err := os.Create(p.mem)
+		if err != nil {
+			return err
+		}
+		runtime.GC() // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("writing heap profile: %s", err)
+		}
+		return f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// docSites maps the documentation services of -docsite to the templates of
+// the URLs of their documentation, as -redirect-url gives.
+var docSites = map[string]string{
+	"pkggodev": "https://pkg.go.dev/{{ .ImportPath }}",
+	"godoc":    "https://godoc.org/{{ .ImportPath }}",
+	"none":     "",
+}
+
+// redirectURLArgs is the data the template of -redirect-url is executed
+// with.
+type redirectURLArgs struct {
+	ImportPath string
+}
+
+// parseRedirectURL parses the template of -redirect-url, and checks that
+// it yields an absolute URL.
+func parseRedirectURL(text string) (*template.Template, error) {
+	t, err := template.New("redirect-url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	s, err := redirectURL(t, "example.org/pkg")
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(s); err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", s)
+	}
+	return t, nil
+}
+
+// redirectURL returns the URL that the page for importPath redirects to.
+func redirectURL(t *template.Template, importPath string) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, redirectURLArgs{importPath}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// redirectURLParts returns the parts of the redirect URL before and after
+// the import path, for the platforms of -platform that build the URL as
+// they serve a request. The template must use the import path once, as is.
+func redirectURLParts(t *template.Template) (before, after string, err error) {
+	const marker = "\x00"
+	s, err := redirectURL(t, marker)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.Count(s, marker) != 1 {
+		return "", "", fmt.Errorf("the -redirect-url template must use .ImportPath once, unchanged")
+	}
+	i := strings.Index(s, marker)
+	return s[:i], s[i+len(marker):], nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// redirectsName is the name of the redirects file, at the root of the site.
+const redirectsName = "_redirects"
+
+// redirectsFile returns a _redirects file, in the format read by Netlify
+// and Cloudflare Pages, that serves the page for each import prefix of the
+// package pages among files at every path under the prefix without a page
+// of its own, with status 200, so that go get resolves the import paths of
+// packages added since the site was generated. See
+// https://docs.netlify.com/routing/redirects/. The generated pages take
+// precedence, as the rules aren't forced. The rules of other import
+// prefixes are kept from the file previously generated at existing, if any.
+func redirectsFile(s site, existing string, files []File) (File, error) {
+	prefixes := make(map[string]bool)
+	for _, f := range files {
+		if f.page == nil || !strings.HasPrefix(f.path, s.host+"/") {
+			continue
+		}
+		prefixes[s.sitePath(f.page.GoImport.ImportPrefix)] = true
+	}
+
+	var rules [][]string
+	for p := range prefixes {
+		rules = append(rules, []string{path.Join(p, "*"), path.Join(p, "index.html"), "200"})
+	}
+
+	b, err := ioutil.ReadFile(existing)
+	switch {
+	case err == nil:
+		sc := bufio.NewScanner(bytes.NewReader(b))
+	Old:
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			from := strings.TrimSuffix(strings.TrimSuffix(fields[0], "*"), "/")
+			for p := range prefixes {
+				if from == strings.TrimSuffix(p, "/") {
+					continue Old
+				}
+			}
+			rules = append(rules, fields)
+		}
+		if err := sc.Err(); err != nil {
+			return File{}, err
+		}
+	case !os.IsNotExist(err):
+		return File{}, err
+	}
+	// Netlify applies the first rule that matches, so the rules of longer
+	// prefixes, which may be under others, go first.
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i][0]) != len(rules[j][0]) {
+			return len(rules[i][0]) > len(rules[j][0])
+		}
+		return rules[i][0] < rules[j][0]
+	})
+
+	f := File{path: path.Join(s.host, redirectsName)}
+	for _, r := range rules {
+		fmt.Fprintf(&f.contents, "%s\n", strings.Join(r, "  "))
+	}
+	return f, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// robotsName is the name of the robots.txt file, at the root of the site.
+const robotsName = "robots.txt"
+
+// robotsFile returns the robots.txt for the site, which lets crawlers index
+// the pages if allow is set and keeps them away otherwise. With a sitemap,
+// it names the sitemap for the crawlers allowed. Like the _headers file, it
+// applies to the whole site.
+func robotsFile(s site, allow, withSitemap bool) File {
+	f := File{path: path.Join(s.host, robotsName)}
+	f.contents.WriteString("User-agent: *\n")
+	if allow {
+		f.contents.WriteString("Allow: /\n")
+	} else {
+		f.contents.WriteString("Disallow: /\n")
+	}
+	if allow && withSitemap {
+		fmt.Fprintf(&f.contents, "\nSitemap: https://%s/%s\n", s.host, sitemapName)
+	}
+	return f
+}
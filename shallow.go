@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	"gopkg.in/src-d/go-git.v3/formats/pktline"
+)
+
+// fetchDepth is the number of commits of history fetched for each wanted
+// commit, given by -depth. Zero fetches the full history. metaimport only
+// reads the trees of the commits it wants, so by default it asks for a
+// shallow fetch.
+var fetchDepth = 1
+
+// encodeUploadPackRequest encodes the request to upload-pack, asking for
+// a shallow pack if fetchDepth is set, and for no blobs if the request is
+// a tree-only one. go-git's own encoding has no way to ask for either.
+// advertised are the capabilities the remote advertised, if known.
+func encodeUploadPackRequest(r *common.GitUploadPackRequest, advertised *common.Capabilities) *strings.Reader {
+	var caps []string
+	if advertised != nil && advertised.Supports("multi_ack_detailed") {
+		// Asked for by git, and required by some hosts, such as Azure
+		// DevOps. Without haves, the response is the same.
+		caps = append(caps, "multi_ack_detailed")
+	}
+	if shallowRequest(r) {
+		caps = append(caps, "shallow")
+	}
+	if treeRequests[r] {
+		caps = append(caps, "filter")
+	}
+	e := pktline.NewEncoder()
+	for i, want := range r.Wants {
+		if i == 0 && len(caps) > 0 {
+			e.AddLine(fmt.Sprintf("want %s %s", want, strings.Join(caps, " ")))
+			continue
+		}
+		e.AddLine(fmt.Sprintf("want %s", want))
+	}
+	if shallowRequest(r) {
+		e.AddLine(fmt.Sprintf("deepen %d", fetchDepth))
+	}
+	if treeRequests[r] {
+		e.AddLine("filter blob:none")
+	}
+	for _, have := range r.Haves {
+		e.AddLine(fmt.Sprintf("have %s", have))
+	}
+	e.AddFlush()
+	e.AddLine("done")
+	return e.Reader()
+}
+
+// shallowRequest reports whether a shallow pack is asked for with the
+// request. Blobs have no history to limit.
+func shallowRequest(r *common.GitUploadPackRequest) bool {
+	return fetchDepth > 0 && !blobRequests[r]
+}
+
+// readUploadPackResponse reads the response to the encoded request up to
+// the packfile: the shallow commits, if a shallow pack was asked for, and
+// the NAK line.
+func readUploadPackResponse(br *bufio.Reader, r *common.GitUploadPackRequest) error {
+	if shallowRequest(r) {
+		if err := skipPktLines(br); err != nil {
+			return err
+		}
+	}
+	line, _, err := readPktLine(br)
+	if err != nil {
+		return err
+	}
+	if line != "NAK" {
+		return fmt.Errorf("unexpected response %q", line)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sitemapName is the name of the sitemap, at the root of the site.
+const sitemapName = "sitemap.xml"
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapFile returns the sitemap listing the URLs of the package pages
+// among files, served by the site. The URLs under other import prefixes
+// are kept from the sitemap previously generated at existing, if any.
+func sitemapFile(s site, existing string, files []File, generated time.Time) (File, error) {
+	var urls []sitemapURL
+	lastMod := generated.Format(time.RFC3339)
+	prefixes := []string{s.importPrefix}
+	for _, f := range files {
+		if f.page == nil || !strings.HasPrefix(f.path, s.host+"/") {
+			continue
+		}
+		urls = append(urls, sitemapURL{"https://" + f.page.ImportPath, lastMod})
+		prefixes = append(prefixes, f.page.GoImport.ImportPrefix)
+	}
+
+	b, err := ioutil.ReadFile(existing)
+	switch {
+	case err == nil:
+		var old sitemap
+		if err := xml.Unmarshal(b, &old); err != nil {
+			return File{}, err
+		}
+	Old:
+		for _, u := range old.URLs {
+			p := strings.TrimPrefix(u.Loc, "https://")
+			for _, prefix := range prefixes {
+				if p == prefix || strings.HasPrefix(p, prefix+"/") {
+					continue Old
+				}
+			}
+			urls = append(urls, u)
+		}
+	case !os.IsNotExist(err):
+		return File{}, err
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+
+	f := File{path: path.Join(s.host, sitemapName)}
+	b, err = xml.MarshalIndent(sitemap{URLs: urls}, "", "  ")
+	if err != nil {
+		return f, err
+	}
+	f.contents.WriteString(xml.Header)
+	f.contents.Write(b)
+	f.contents.WriteByte('\n')
+	return f, nil
+}
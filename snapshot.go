@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rollbackHelp = `usage: metaimport rollback [flags] [domain]
+
+rollback restores the output directory from the most recent snapshot taken
+by -snapshots, and removes the snapshot, so that repeated rollbacks go
+further back. 'domain' is the vanity domain, or an import path on the
+domain, and is required with -deploy.
+
+Flags
+   -deploy     Deploy the restored site: "netlify" or "cloudflare" (Cloudflare Pages).
+               Requires -site and credentials in the environment, as for generation.
+   -o          Output directory to restore (default: html).
+   -site       Site to deploy to: the Netlify site ID or domain, or the Cloudflare
+               Pages project name.
+   -snapshots  Directory of snapshots (required).
+
+Examples
+   metaimport rollback -snapshots state
+   metaimport rollback -snapshots state -deploy netlify -site mysite example.org
+`
+
+const (
+	// snapshotLayout is the time layout of snapshot directory names.
+	snapshotLayout = "20060102T150405.000000000Z"
+	// maxSnapshots is the number of snapshots kept in the directory of
+	// snapshots. Older snapshots are removed.
+	maxSnapshots = 10
+)
+
+// snapshot copies the output directory, if it exists, to a new timestamped
+// snapshot in the directory of snapshots, and removes the oldest snapshots
+// beyond maxSnapshots.
+func snapshot(outputDir, snapshotDir string) error {
+	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+		return nil
+	}
+	dst := filepath.Join(snapshotDir, time.Now().UTC().Format(snapshotLayout))
+	if err := copyDir(outputDir, dst); err != nil {
+		return err
+	}
+	names, err := snapshots(snapshotDir)
+	if err != nil {
+		return err
+	}
+	for len(names) > maxSnapshots {
+		if err := os.RemoveAll(filepath.Join(snapshotDir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// checkSnapshotDir returns an error if the directory of snapshots is the
+// output directory or inside it, where the snapshots would be copied into
+// later snapshots and deployed, and removed when the output is replaced.
+func checkSnapshotDir(outputDir, snapshotDir string) error {
+	out, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	snap, err := filepath.Abs(snapshotDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(out, snap); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("the snapshots directory %s must be outside the output directory %s", snapshotDir, outputDir)
+	}
+	return nil
+}
+
+// snapshots returns the names of the snapshots in the directory, oldest
+// first.
+func snapshots(snapshotDir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if _, err := time.Parse(snapshotLayout, fi.Name()); err == nil && fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names) // the layout sorts chronologically
+	return names, nil
+}
+
+// copyDir copies the regular files in the directory tree src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, permDir)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, b, permFile)
+	})
+}
+
+func runRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, rollbackHelp)
+		os.Exit(2)
+	}
+	deployTarget := fs.String("deploy", "", "")
+	outputDir := fs.String("o", "html", "")
+	site := fs.String("site", "", "")
+	snapshotDir := fs.String("snapshots", "", "")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 || *snapshotDir == "" || (*deployTarget != "" && fs.NArg() != 1) {
+		fs.Usage()
+	}
+
+	if err := checkSnapshotDir(*outputDir, *snapshotDir); err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	var dep deployer // can be nil
+	if *deployTarget != "" {
+		var err error
+		dep, err = newDeployer(*deployTarget, *site)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	names, err := snapshots(*snapshotDir)
+	if err != nil {
+		log.Fatalf("reading snapshots: %s", err)
+	}
+	if len(names) == 0 {
+		log.Fatalf("no snapshots in %s", *snapshotDir)
+	}
+	latest := filepath.Join(*snapshotDir, names[len(names)-1])
+
+	if err := os.RemoveAll(*outputDir); err != nil {
+		log.Fatalf("removing %s: %s", *outputDir, err)
+	}
+	if err := copyDir(latest, *outputDir); err != nil {
+		log.Fatalf("restoring %s: %s", latest, err)
+	}
+	if err := os.RemoveAll(latest); err != nil {
+		log.Fatalf("removing snapshot %s: %s", latest, err)
+	}
+	infof("restored %s from snapshot %s", *outputDir, names[len(names)-1])
+
+	if dep != nil {
+		siteDir := filepath.Join(*outputDir, newSite(fs.Arg(0)).host)
+		if err := dep.deploy(siteDir); err != nil {
+			log.Fatalf("deploying to %s: %s", *deployTarget, err)
+		}
+		infof("deployed %s to %s", siteDir, *deployTarget)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestCheckSnapshotDir(t *testing.T) {
+	for _, tt := range []struct {
+		out, snap string
+		ok        bool
+	}{
+		{"html", "state", true},
+		{"html", "html-state", true},
+		{"html", "../html", true},
+		{"html", "html", false},
+		{"html", "html/state", false},
+		{"html/", "./html/example.org/state", false},
+		{"/tmp/html", "/tmp/html/state", false},
+	} {
+		err := checkSnapshotDir(tt.out, tt.snap)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkSnapshotDir(%q, %q) = %v, want ok %v", tt.out, tt.snap, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// A sourceKind returns the go-source formats of the repositories hosted by
+// a kind of software, given the URL at which the repository is browsed, the
+// tree and the name of the default branch.
+type sourceKind func(repoURL string, ref treeRef, def string) GodocSpec
+
+// sourceKinds is the registry of the kinds of software whose go-source
+// formats are known. The templates in the file given by -source-hosts are
+// registered under the names of their hosts.
+var sourceKinds = map[string]sourceKind{
+	"github": func(repoURL string, ref treeRef, def string) GodocSpec {
+		return GitHub{repoURL, ref.nameOr(def)}
+	},
+	"gitlab": func(repoURL string, ref treeRef, def string) GodocSpec {
+		return GitLab{strings.TrimSuffix(repoURL, ".git"), ref.nameOr(def)}
+	},
+	"gitea": giteaSpec,
+	// Forgejo is a fork of Gitea, with the same formats.
+	"forgejo": giteaSpec,
+	"gogs": func(repoURL string, ref treeRef, def string) GodocSpec {
+		return Gogs{strings.TrimSuffix(repoURL, ".git"), ref.nameOr(def)}
+	},
+	"gitiles": func(repoURL string, ref treeRef, def string) GodocSpec {
+		return Gitiles{gitilesURL(repoURL), ref.fullName(def)}
+	},
+	"gerrit": func(repoURL string, ref treeRef, def string) GodocSpec {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return Default{repoURL}
+		}
+		return Gitiles{gerritGitilesURL(u), ref.fullName(def)}
+	},
+	"cgit": func(repoURL string, ref treeRef, def string) GodocSpec {
+		if ref.rev != "" {
+			return Cgit{repoURL, "id=" + ref.rev}
+		}
+		return Cgit{repoURL, "h=" + ref.nameOr(def)}
+	},
+	"gitweb": func(repoURL string, ref treeRef, def string) GodocSpec {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return Default{repoURL}
+		}
+		return Gitweb{gitwebURL(u), strings.TrimPrefix(u.Path, "/"), ref.fullName(def)}
+	},
+	"bitbucket-server": func(repoURL string, ref treeRef, def string) GodocSpec {
+		b, ok := bitbucketServerURL(repoURL)
+		if !ok {
+			return Default{repoURL}
+		}
+		return BitbucketServer{b, ref.fullName(def)}
+	},
+	// Bitbucket's formats name the head of the default branch only.
+	"bitbucket": func(repoURL string, ref treeRef, def string) GodocSpec {
+		if ref == (treeRef{}) || def == ref.branch {
+			return BitBucket{repoURL}
+		}
+		return Default{repoURL}
+	},
+}
+
+// giteaSpec returns the formats of Gitea, which name the kind of ref before
+// the ref.
+func giteaSpec(repoURL string, ref treeRef, def string) GodocSpec {
+	r := "branch/" + def
+	switch {
+	case ref.rev != "":
+		r = "commit/" + ref.rev
+	case ref.tag != "":
+		r = "tag/" + ref.tag
+	case ref.branch != "":
+		r = "branch/" + ref.branch
+	}
+	return Gitea{strings.TrimSuffix(repoURL, ".git"), r}
+}
+
+// sourceHosts maps the hosts whose go-source formats are known to the kind
+// of their software, which -source-host gives for other hosts.
+var sourceHosts = map[string]string{
+	"github.com":    "github",
+	"gitlab.com":    "gitlab",
+	"bitbucket.org": "bitbucket",
+	"gitea.com":     "gitea",
+	// Codeberg runs Forgejo.
+	"codeberg.org": "forgejo",
+	// Launchpad browses git repositories with cgit.
+	"git.launchpad.net": "cgit",
+}
+
+// forcibleSourceHosts are the kinds of software -source-host accepts, those
+// that can be self-hosted under any name.
+var forcibleSourceHosts = map[string]bool{
+	"github":  true,
+	"gitlab":  true,
+	"gitea":   true,
+	"forgejo": true,
+	"gogs":    true,
+	"gitiles": true,
+	"gerrit":  true,
+	"cgit":    true,
+	"gitweb":  true,
+
+	"bitbucket-server": true,
+}
+
+// readSourceHosts adds the hosts in the file given by -source-hosts to the
+// registry. Each line names a host and either the kind of its software, as
+// -source-host does, or the templates of its home, directory and file
+// formats, in which {repo} stands for the URL of the repository, without
+// any .git suffix, and {ref} for the branch, tag or revision:
+//
+//	git.example.org  gitlab
+//	src.example.org  {repo}  {repo}/tree/{ref}{/dir}  {repo}/tree/{ref}{/dir}/{file}#L{line}
+//
+// Blank lines and lines beginning with '#' are ignored.
+func readSourceHosts(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 2:
+			if !forcibleSourceHosts[fields[1]] {
+				return fmt.Errorf("%s:%d: unknown source host %q", file, n, fields[1])
+			}
+			sourceHosts[fields[0]] = fields[1]
+		case 4:
+			t := Template{homeURL: fields[1], dirURL: fields[2], fileURL: fields[3]}
+			sourceKinds[fields[0]] = t.spec
+			sourceHosts[fields[0]] = fields[0]
+		default:
+			return fmt.Errorf("%s:%d: want a host and its kind or its home, directory and file templates", file, n)
+		}
+	}
+	return s.Err()
+}
+
+// Template is the go-source formats given by templates in the file of
+// -source-hosts, for a repository and tree.
+type Template struct {
+	homeURL, dirURL, fileURL string
+	repo, ref                string
+}
+
+func (t Template) spec(repoURL string, ref treeRef, def string) GodocSpec {
+	t.repo, t.ref = strings.TrimSuffix(repoURL, ".git"), ref.nameOr(def)
+	return t
+}
+
+func (t Template) expand(s string) string {
+	return strings.NewReplacer("{repo}", t.repo, "{ref}", t.ref).Replace(s)
+}
+
+func (t Template) home() string      { return t.expand(t.homeURL) }
+func (t Template) directory() string { return t.expand(t.dirURL) }
+func (t Template) file() string      { return t.expand(t.fileURL) }
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/src-d/go-git.v3/clients"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	"gopkg.in/src-d/go-git.v3/formats/pktline"
+)
+
+// sshKeyFile is the identity file given by -ssh-key, if any.
+var sshKeyFile string
+
+// scpSyntaxRe matches the SCP-like addresses used by Git to access
+// repositories by SSH. From cmd/go.
+var scpSyntaxRe = regexp.MustCompile(`^([a-zA-Z0-9_]+)@([a-zA-Z0-9._-]+):(.*)$`)
+
+// An sshRemote is the address of a repository accessed by SSH.
+type sshRemote struct {
+	user, host, port string
+	path             string // as given to git-upload-pack
+}
+
+// parseSSHURL parses ssh://[user@]host[:port]/path URLs and SCP-like
+// user@host:path addresses, and reports whether repoURL is either.
+func parseSSHURL(repoURL string) (sshRemote, bool) {
+	if m := scpSyntaxRe.FindStringSubmatch(repoURL); m != nil {
+		return sshRemote{user: m[1], host: m[2], port: "22", path: m[3]}, true
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "ssh" && u.Scheme != "git+ssh") || u.Host == "" {
+		return sshRemote{}, false
+	}
+	r := sshRemote{user: u.User.Username(), host: u.Hostname(), port: u.Port(), path: u.Path}
+	if r.user == "" {
+		r.user = "git"
+	}
+	if r.port == "" {
+		r.port = "22"
+	}
+	return r, true
+}
+
+// sshHTTPSURL returns the https URL of the repository at the SSH URL or
+// SCP-like address, for hosts that serve repositories over both, such as
+// GitHub, and reports whether repoURL is either.
+func sshHTTPSURL(repoURL string) (string, bool) {
+	r, ok := parseSSHURL(repoURL)
+	if !ok {
+		return "", false
+	}
+	u := url.URL{Scheme: "https", Host: r.host, Path: "/" + strings.TrimPrefix(r.path, "/")}
+	return u.String(), true
+}
+
+// installSSHProtocol makes go-git fetch ssh URLs from the remote, with the
+// credentials of ssh-agent and -ssh-key, and returns the ssh URL to use for
+// the repository. Unlike the SSH client of go-git, it works with any host.
+// If GIT_SSH_COMMAND or GIT_SSH is set, the command is used to connect to
+// the remote instead, as git does, so that the user's ssh configuration,
+// such as jump hosts, applies.
+func installSSHProtocol(r sshRemote) (string, error) {
+	if os.Getenv("GIT_SSH_COMMAND") != "" || os.Getenv("GIT_SSH") != "" {
+		debugf("connecting to %s with the command in GIT_SSH_COMMAND or GIT_SSH", r.host)
+		clients.InstallProtocol("ssh", &sshCmdUploadPack{remote: r})
+	} else {
+		auth, err := sshAuthMethods()
+		if err != nil {
+			return "", err
+		}
+		hostKeys, err := sshHostKeyCallback()
+		if err != nil {
+			return "", err
+		}
+		clients.InstallProtocol("ssh", &sshUploadPack{remote: r, config: &ssh.ClientConfig{
+			User:            r.user,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+		}})
+	}
+	u := url.URL{Scheme: "ssh", User: url.User(r.user), Host: net.JoinHostPort(r.host, r.port), Path: "/" + strings.TrimPrefix(r.path, "/")}
+	return u.String(), nil
+}
+
+// sshAuthMethods returns the keys of -ssh-key and of the running
+// ssh-agent, if any.
+func sshAuthMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sshKeyFile != "" {
+		b, err := ioutil.ReadFile(sshKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %s (add encrypted keys to ssh-agent instead)", sshKeyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to ssh-agent: %s", err)
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no SSH credentials: use -ssh-key or run ssh-agent")
+	}
+	return methods, nil
+}
+
+// sshHostKeyCallback verifies host keys against the user's known_hosts
+// file, as ssh does.
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(home, ".ssh", "known_hosts")
+	cb, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %s", err)
+	}
+	return cb, nil
+}
+
+// sshUploadPack is a go-git upload pack service that runs git-upload-pack
+// on the remote over SSH.
+type sshUploadPack struct {
+	remote sshRemote
+	config *ssh.ClientConfig
+	client *ssh.Client
+	caps   *common.Capabilities // advertised by the remote
+}
+
+func (s *sshUploadPack) Connect(common.Endpoint) error {
+	if s.client != nil {
+		return nil
+	}
+	// As ssh.Dial does, but with -timeout for the handshake too.
+	addr := net.JoinHostPort(s.remote.host, s.remote.port)
+	conn, err := net.DialTimeout("tcp", addr, fetchTimeout)
+	if err != nil {
+		return err
+	}
+	if fetchTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(fetchTimeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, s.config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	s.client = ssh.NewClient(c, chans, reqs)
+	return nil
+}
+
+func (s *sshUploadPack) ConnectWithAuth(ep common.Endpoint, _ common.AuthMethod) error {
+	return s.Connect(ep)
+}
+
+func (s *sshUploadPack) command() string {
+	return s.remote.command()
+}
+
+// command returns the command that runs git-upload-pack for the repository
+// on the remote.
+func (r sshRemote) command() string {
+	return "git-upload-pack '" + strings.Replace(r.path, "'", `'\''`, -1) + "'"
+}
+
+func (s *sshUploadPack) Info() (*common.GitUploadPackInfo, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	// With a flush-pkt for the request, git-upload-pack exits after
+	// advertising the refs.
+	session.Stdin = strings.NewReader("0000")
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	w := newWatchdog(func() { session.Close() })
+	out, err := session.Output(s.command())
+	w.stop()
+	if err = w.check(err); err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", s.command(), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	i := common.NewGitUploadPackInfo()
+	s.caps = i.Capabilities
+	return i, i.Decode(pktline.NewDecoder(bytes.NewReader(out)))
+}
+
+func (s *sshUploadPack) Fetch(r *common.GitUploadPackRequest) (io.ReadCloser, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start(s.command()); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	w := newWatchdog(func() { session.Close() })
+	br, err := fetchPack(stdin, w.reader(stdout), r, s.caps)
+	if err != nil {
+		w.stop()
+		session.Close()
+		return nil, w.check(err)
+	}
+	return w.readCloser(sessionReadCloser{br, session}), nil
+}
+
+// fetchPack sends the request to a git-upload-pack speaking the stateful
+// protocol, and returns its output positioned at the packfile.
+func fetchPack(stdin io.WriteCloser, stdout io.Reader, r *common.GitUploadPackRequest, caps *common.Capabilities) (*bufio.Reader, error) {
+	// Skip the advertisement, send the request, and read the response up
+	// to the packfile.
+	br := bufio.NewReader(stdout)
+	if err := skipPktLines(br); err != nil {
+		return nil, fmt.Errorf("reading advertisement: %s", err)
+	}
+	if _, err := io.Copy(stdin, encodeUploadPackRequest(r, caps)); err != nil {
+		return nil, err
+	}
+	// The request ends with "done", so nothing more is sent, and closing
+	// stdin lets the remote end the session once the packfile is sent.
+	stdin.Close()
+	if err := readUploadPackResponse(br, r); err != nil {
+		return nil, fmt.Errorf("reading response: %s", err)
+	}
+	return br, nil
+}
+
+// skipPktLines reads pkt-lines up to and including a flush-pkt.
+func skipPktLines(r *bufio.Reader) error {
+	for {
+		_, flush, err := readPktLine(r)
+		if err != nil || flush {
+			return err
+		}
+	}
+}
+
+// readPktLine reads a pkt-line and returns its payload, or reports that
+// it is a flush-pkt. An error line sent by the remote is returned as an
+// error.
+func readPktLine(r *bufio.Reader) (line string, flush bool, err error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", false, err
+	}
+	l, err := strconv.ParseUint(string(n[:]), 16, 16)
+	if err != nil || (l != 0 && l < 4) {
+		return "", false, fmt.Errorf("invalid pkt-line length %q", n)
+	}
+	if l == 0 {
+		return "", true, nil
+	}
+	b := make([]byte, l-4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", false, err
+	}
+	line = strings.TrimSuffix(string(b), "\n")
+	if strings.HasPrefix(line, "ERR ") {
+		return "", false, errors.New(strings.TrimPrefix(line, "ERR "))
+	}
+	return line, false, nil
+}
+
+// sessionReadCloser reads the output of an SSH session, and closes the
+// session on Close.
+type sessionReadCloser struct {
+	io.Reader
+	session *ssh.Session
+}
+
+func (s sessionReadCloser) Close() error {
+	return s.session.Close()
+}
+
+// sshCmdUploadPack is a go-git upload pack service that runs
+// git-upload-pack on the remote with the command in GIT_SSH_COMMAND, run
+// by the shell, or the program in GIT_SSH, as git does.
+type sshCmdUploadPack struct {
+	remote sshRemote
+	caps   *common.Capabilities // advertised by the remote
+}
+
+func (s *sshCmdUploadPack) Connect(common.Endpoint) error { return nil }
+
+func (s *sshCmdUploadPack) ConnectWithAuth(common.Endpoint, common.AuthMethod) error { return nil }
+
+func (s *sshCmdUploadPack) cmd() *exec.Cmd {
+	var args []string
+	if s.remote.port != "22" {
+		args = append(args, "-p", s.remote.port)
+	}
+	args = append(args, s.remote.user+"@"+s.remote.host, s.remote.command())
+	var cmd *exec.Cmd
+	if c := os.Getenv("GIT_SSH_COMMAND"); c != "" {
+		cmd = exec.Command("sh", append([]string{"-c", c + ` "$@"`, c}, args...)...)
+	} else {
+		cmd = exec.Command(os.Getenv("GIT_SSH"), args...)
+	}
+	// When the command is killed on timeout, the ssh started by the shell
+	// may keep its output open, so don't wait for it.
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+func (s *sshCmdUploadPack) Info() (*common.GitUploadPackInfo, error) {
+	cmd := s.cmd()
+	// With a flush-pkt for the request, git-upload-pack exits after
+	// advertising the refs.
+	cmd.Stdin = strings.NewReader("0000")
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	w := newWatchdog(func() { cmd.Process.Kill() })
+	err := w.check(cmd.Wait())
+	w.stop()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", s.remote.command(), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	i := common.NewGitUploadPackInfo()
+	s.caps = i.Capabilities
+	return i, i.Decode(pktline.NewDecoder(&out))
+}
+
+func (s *sshCmdUploadPack) Fetch(r *common.GitUploadPackRequest) (io.ReadCloser, error) {
+	cmd := s.cmd()
+	// Connection errors are reported by Info, so only show the progress
+	// of the remote when debugging.
+	if level >= levelDebug {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	w := newWatchdog(func() { cmd.Process.Kill() })
+	br, err := fetchPack(stdin, w.reader(stdout), r, s.caps)
+	if err != nil {
+		w.stop()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, w.check(err)
+	}
+	return w.readCloser(cmdReadCloser{br, cmd}), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/src-d/go-git.v3/core"
+	"gopkg.in/src-d/go-git.v3/formats/packfile"
+	"gopkg.in/src-d/go-git.v3/storage/memory"
+)
+
+// storageKind is where fetched git objects are kept, given by -storage:
+// "memory" or "disk".
+var storageKind = "memory"
+
+// decodePack decodes the fetched packfile into the storage. With disk
+// storage, the packfile is first written to a temporary file, so that
+// objects needn't be held in memory to resolve deltas against them.
+func decodePack(r io.Reader, s core.ObjectStorage) error {
+	if storageKind != "disk" {
+		return packfile.NewDecoder(packfile.NewStream(r)).Decode(s)
+	}
+	f, err := ioutil.TempFile("", "metaimport-pack")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return packfile.NewDecoder(packfile.NewSeekable(f)).Decode(s)
+}
+
+// diskStorage is a go-git object storage that keeps the contents of
+// objects in a temporary file, and only their locations in memory, for
+// repositories too large to hold in memory.
+type diskStorage struct {
+	f       *os.File
+	size    int64 // of the file
+	objects map[core.Hash]diskObject
+}
+
+type diskObject struct {
+	typ          core.ObjectType
+	offset, size int64
+}
+
+func newDiskStorage() (*diskStorage, error) {
+	f, err := ioutil.TempFile("", "metaimport-objects")
+	if err != nil {
+		return nil, err
+	}
+	return &diskStorage{f: f, objects: make(map[core.Hash]diskObject)}, nil
+}
+
+func (s *diskStorage) Set(obj core.Object) (core.Hash, error) {
+	h := obj.Hash()
+	if _, ok := s.objects[h]; ok {
+		return h, nil
+	}
+	n, err := s.f.Write(obj.Content())
+	if err != nil {
+		return h, err
+	}
+	s.objects[h] = diskObject{obj.Type(), s.size, int64(n)}
+	s.size += int64(n)
+	return h, nil
+}
+
+func (s *diskStorage) Get(h core.Hash) (core.Object, error) {
+	o, ok := s.objects[h]
+	if !ok {
+		return nil, core.ErrObjectNotFound
+	}
+	b := make([]byte, o.size)
+	if _, err := s.f.ReadAt(b, o.offset); err != nil {
+		return nil, fmt.Errorf("reading object %s: %s", h, err)
+	}
+	return memory.NewObject(o.typ, o.size, b), nil
+}
+
+func (s *diskStorage) Iter(t core.ObjectType) (core.ObjectIter, error) {
+	var objs []core.Object
+	for h, o := range s.objects {
+		if o.typ != t {
+			continue
+		}
+		obj, err := s.Get(h)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return core.NewObjectSliceIter(objs), nil
+}
+
+// close removes the file of the objects.
+func (s *diskStorage) close() error {
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
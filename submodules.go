@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gitcore "gopkg.in/src-d/go-git.v3/core"
+)
+
+// withSubmodules, set by -submodules, makes the trees of git repositories
+// include the trees of their submodules, so that package directories in
+// submodules get pages too.
+var withSubmodules bool
+
+// gitlinkMode is the mode of the tree entries of submodules.
+const gitlinkMode os.FileMode = 0160000
+
+// A submodule is an entry of a .gitmodules file.
+type submodule struct {
+	path string
+	url  string
+}
+
+// parseGitmodules parses the submodules of the lines of a .gitmodules file,
+// which is in git config syntax.
+func parseGitmodules(lines []string) []submodule {
+	var subs []submodule
+	var cur *submodule
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", line[0] == '#', line[0] == ';':
+			continue
+		case strings.HasPrefix(line, "[submodule "):
+			subs = append(subs, submodule{})
+			cur = &subs[len(subs)-1]
+			continue
+		case line[0] == '[':
+			cur = nil
+			continue
+		}
+		i := strings.Index(line, "=")
+		if cur == nil || i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.Trim(strings.TrimSpace(line[i+1:]), `"`)
+		switch key {
+		case "path":
+			cur.path = strings.Trim(value, "/")
+		case "url":
+			cur.url = value
+		}
+	}
+	return subs
+}
+
+// resolveSubmoduleURL resolves the URL of a submodule, which may be
+// relative to the URL of the superproject, as git does.
+func resolveSubmoduleURL(superURL, subURL string) (string, error) {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL, nil
+	}
+	if dir, ok := localRepoPath(superURL); ok {
+		return filepath.Join(dir, filepath.FromSlash(subURL)), nil
+	}
+	if m := scpSyntaxRe.FindStringSubmatch(superURL); m != nil {
+		return m[1] + "@" + m[2] + ":" + path.Join(m[3], subURL), nil
+	}
+	u, err := url.Parse(superURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, subURL)
+	return u.String(), nil
+}
+
+// submodules returns the tree with the trees of the submodules listed in
+// its .gitmodules file overlaid at their paths.
+func (g *gitBackend) submodules(t gitTree) (sourceTree, error) {
+	f, err := t.file(".gitmodules")
+	if err == errFileNotFound {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines, err := f.lines()
+	if err != nil {
+		return nil, fmt.Errorf("reading .gitmodules: %s", err)
+	}
+	subs := make(map[string]sourceTree)
+	for _, sm := range parseGitmodules(lines) {
+		if sm.path == "" || sm.url == "" {
+			continue
+		}
+		h, ok := t.gitlink(sm.path)
+		if !ok {
+			// Listed, but removed from the tree.
+			debugf("skipping submodule %s: not in tree", sm.path)
+			continue
+		}
+		subURL, err := resolveSubmoduleURL(g.url, sm.url)
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %s", sm.path, err)
+		}
+		debugf("fetching submodule %s from %s", sm.path, subURL)
+		b, err := newGitBackend(subURL)
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %s", sm.path, err)
+		}
+		g.subs = append(g.subs, b)
+		st, _, err := b.tree(treeRef{rev: h.String()})
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %s", sm.path, err)
+		}
+		subs[sm.path] = st
+	}
+	if len(subs) == 0 {
+		return t, nil
+	}
+	return submoduleTree{t, subs}, nil
+}
+
+// gitlink returns the commit of the submodule at the path p, and reports
+// whether there is one.
+func (t gitTree) gitlink(p string) (gitcore.Hash, bool) {
+	dir, err := subtree(t.repo, t.tree, path.Dir(p))
+	if err != nil {
+		return gitcore.Hash{}, false
+	}
+	for _, e := range dir.Entries {
+		if e.Name == path.Base(p) && e.Mode == gitlinkMode {
+			return e.Hash, true
+		}
+	}
+	return gitcore.Hash{}, false
+}
+
+// submoduleTree is a sourceTree with the trees of submodules overlaid at
+// their paths.
+type submoduleTree struct {
+	sourceTree
+	subs map[string]sourceTree // by path
+}
+
+// lookup returns the submodule tree containing the path, and the path
+// relative to it, if any.
+func (t submoduleTree) lookup(name string) (sourceTree, string, bool) {
+	for p, sub := range t.subs {
+		if name == p {
+			return sub, ".", true
+		}
+		if strings.HasPrefix(name, p+"/") {
+			return sub, name[len(p)+1:], true
+		}
+	}
+	return nil, "", false
+}
+
+func (t submoduleTree) files() ([]sourceFile, error) {
+	files, err := t.sourceTree.files()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for p := range t.subs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		sub, err := t.subs[p].files()
+		if err != nil {
+			return nil, fmt.Errorf("submodule %s: %s", p, err)
+		}
+		for _, f := range sub {
+			files = append(files, sourceFile{p + "/" + f.name, f.read})
+		}
+	}
+	return files, nil
+}
+
+func (t submoduleTree) file(name string) (sourceFile, error) {
+	sub, rel, ok := t.lookup(name)
+	if !ok {
+		return t.sourceTree.file(name)
+	}
+	f, err := sub.file(rel)
+	if err != nil {
+		return sourceFile{}, err
+	}
+	return sourceFile{name, f.read}, nil
+}
+
+func (t submoduleTree) dirEntries(d string) ([]string, error) {
+	if sub, rel, ok := t.lookup(d); ok {
+		return sub.dirEntries(rel)
+	}
+	return t.sourceTree.dirEntries(d)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"path"
+	"strings"
+)
+
+// packageSynopses returns the synopses of the packages in dirs, the first
+// sentences of their package doc comments, by directory. The comment in
+// doc.go, if any, is preferred, as go doc does. Packages without a doc
+// comment are left out.
+func packageSynopses(tree sourceTree, dirs map[string]struct{}) (map[string]string, error) {
+	files, err := tree.files()
+	if err != nil {
+		return nil, err
+	}
+	synopses := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, f := range files {
+		d, name := path.Split(f.name)
+		d = path.Clean(d)
+		if _, ok := dirs[d]; !ok || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") ||
+			strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		if _, ok := synopses[d]; ok && name != "doc.go" {
+			continue
+		}
+		contents, err := f.contents()
+		if err != nil {
+			return nil, err
+		}
+		if isLFSPointer(contents) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f.name, contents, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			debugf("skipping %s for the synopsis: %s", f.name, err)
+			continue
+		}
+		if file.Doc == nil {
+			continue
+		}
+		if s := doc.Synopsis(file.Doc.Text()); s != "" {
+			synopses[d] = s
+		}
+	}
+	return synopses, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// fetchTimeout is set by -timeout. A fetch from a remote that sends
+// nothing for that long is aborted. Zero means no timeout.
+var fetchTimeout time.Duration
+
+// fetchRetries is set by -retries: the number of times a failed fetch of
+// the repository is retried before trying the next mirror, if any.
+var fetchRetries int
+
+// A watchdog calls stop, to abort a request to a remote, if it isn't
+// kicked for fetchTimeout. Without a timeout it never fires.
+type watchdog struct {
+	timer *time.Timer
+	fired int32
+}
+
+func newWatchdog(stop func()) *watchdog {
+	w := &watchdog{}
+	if fetchTimeout > 0 {
+		w.timer = time.AfterFunc(fetchTimeout, func() {
+			atomic.StoreInt32(&w.fired, 1)
+			stop()
+		})
+	}
+	return w
+}
+
+// kick restarts the timeout.
+func (w *watchdog) kick() {
+	if w.timer != nil && atomic.LoadInt32(&w.fired) == 0 {
+		w.timer.Reset(fetchTimeout)
+	}
+}
+
+// stop stops the watchdog, once the request is done.
+func (w *watchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// check returns err, or the timeout error in its place if the watchdog
+// fired, since err is then only the result of aborting the request.
+func (w *watchdog) check(err error) error {
+	if err != nil && atomic.LoadInt32(&w.fired) == 1 {
+		return fmt.Errorf("timed out after %s waiting for the remote", fetchTimeout)
+	}
+	return err
+}
+
+// reader returns a reader of r that kicks the watchdog on each read.
+func (w *watchdog) reader(r io.Reader) io.Reader {
+	return watchedReader{r, w}
+}
+
+type watchedReader struct {
+	r io.Reader
+	w *watchdog
+}
+
+func (r watchedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.w.kick()
+	return n, r.w.check(err)
+}
+
+// readCloser returns a ReadCloser of rc that kicks the watchdog on each
+// read and stops it on close.
+func (w *watchdog) readCloser(rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{w.reader(rc), closerFunc(func() error {
+		w.stop()
+		return rc.Close()
+	})}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
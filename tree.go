@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	git "gopkg.in/src-d/go-git.v3"
+	gitcore "gopkg.in/src-d/go-git.v3/core"
+)
+
+// errFileNotFound is returned by sourceTree.file if there is no regular
+// file at the path.
+var errFileNotFound = errors.New("file not found")
+
+// A sourceTree is the tree of files of a repository at the revision pages
+// are generated for. Paths are slash-separated and relative to the root of
+// the tree.
+type sourceTree interface {
+	// files returns the files in the tree.
+	files() ([]sourceFile, error)
+	// file returns the file at the path.
+	file(name string) (sourceFile, error)
+	// dirEntries returns the names of the entries of the directory d.
+	dirEntries(d string) ([]string, error)
+}
+
+// A sourceFile is a file in a sourceTree.
+type sourceFile struct {
+	name string
+	read func() (string, error)
+}
+
+// contents returns the contents of the file.
+func (f sourceFile) contents() (string, error) {
+	return f.read()
+}
+
+// lines returns the lines of the file, without end of line characters, as
+// (*git.File).Lines does.
+func (f sourceFile) lines() ([]string, error) {
+	c, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(c, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// gitTree is a sourceTree for a tree in a go-git repository. If partial
+// is set, the tree was fetched without its blobs, which are fetched when
+// files are read.
+type gitTree struct {
+	repo    *git.Repository
+	tree    *git.Tree
+	partial bool
+}
+
+// treeMode is the mode of the tree entries of directories.
+const treeMode os.FileMode = 0040000
+
+func (t gitTree) files() ([]sourceFile, error) {
+	var files []sourceFile
+	err := t.walk(t.tree, "", &files)
+	return files, err
+}
+
+// walk appends the files in the tree, whose path is dir, to files, in the
+// order of go-git's tree walker. The Go files of a directory are read
+// together, since the tree's blobs may have to be fetched.
+func (t gitTree) walk(tree *git.Tree, dir string, files *[]sourceFile) error {
+	var goFiles []gitcore.Hash
+	for _, e := range tree.Entries {
+		if e.Mode != treeMode && strings.HasSuffix(e.Name, ".go") {
+			goFiles = append(goFiles, e.Hash)
+		}
+	}
+	for _, e := range tree.Entries {
+		name := path.Join(dir, e.Name)
+		switch {
+		case e.Mode == gitlinkMode:
+			// A submodule, whose commit isn't in the repository.
+		case e.Mode == treeMode:
+			sub, err := t.repo.Tree(e.Hash)
+			if err != nil {
+				return fmt.Errorf("getting tree for %s: %s", name, err)
+			}
+			if err := t.walk(sub, name, files); err != nil {
+				return err
+			}
+		case strings.HasSuffix(e.Name, ".go"):
+			*files = append(*files, t.sourceFile(name, e.Hash, goFiles))
+		default:
+			*files = append(*files, t.sourceFile(name, e.Hash, nil))
+		}
+	}
+	return nil
+}
+
+func (t gitTree) file(name string) (sourceFile, error) {
+	dir, err := subtree(t.repo, t.tree, path.Dir(name))
+	if err != nil {
+		return sourceFile{}, errFileNotFound
+	}
+	for _, e := range dir.Entries {
+		if e.Name == path.Base(name) && e.Mode != treeMode && e.Mode != gitlinkMode {
+			return t.sourceFile(name, e.Hash, nil), nil
+		}
+	}
+	return sourceFile{}, errFileNotFound
+}
+
+// sourceFile returns the file for the blob. If the tree is partial, the
+// blobs of batch that are missing are fetched along with it.
+func (t gitTree) sourceFile(name string, blob gitcore.Hash, batch []gitcore.Hash) sourceFile {
+	return sourceFile{name, func() (string, error) {
+		obj, err := t.repo.Storage.Get(blob)
+		if err == gitcore.ErrObjectNotFound && t.partial {
+			missing := []gitcore.Hash{blob}
+			for _, h := range batch {
+				if _, err := t.repo.Storage.Get(h); h != blob && err == gitcore.ErrObjectNotFound {
+					missing = append(missing, h)
+				}
+			}
+			if err = fetchBlobs(t.repo, missing); err == nil {
+				obj, err = t.repo.Storage.Get(blob)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(obj.Content()), nil
+	}}
+}
+
+func (t gitTree) dirEntries(d string) ([]string, error) {
+	sub, err := subtree(t.repo, t.tree, d)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range sub.Entries {
+		names = append(names, e.Name)
+	}
+	return names, nil
+}
+
+// subtree returns the tree for the directory d, a slash-separated path
+// relative to the root of tree.
+func subtree(repo *git.Repository, tree *git.Tree, d string) (*git.Tree, error) {
+	if d == "." {
+		return tree, nil
+	}
+	t := tree
+	for _, elem := range strings.Split(d, "/") {
+		found := false
+		for _, e := range t.Entries {
+			if e.Name != elem {
+				continue
+			}
+			sub, err := repo.Tree(e.Hash)
+			if err != nil {
+				return nil, fmt.Errorf("getting tree for %s: %s", d, err)
+			}
+			t, found = sub, true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("directory %s not found", d)
+		}
+	}
+	return t, nil
+}
+
+// vcsMetadata holds the names of the files and directories in which VCS
+// tools keep their metadata, which aren't part of the tree of a checkout.
+var vcsMetadata = map[string]bool{
+	".git":      true,
+	".hg":       true,
+	".svn":      true,
+	".bzr":      true,
+	".fslckout": true,
+	"_FOSSIL_":  true,
+}
+
+// dirTree is a sourceTree for a directory on disk, such as a checkout.
+type dirTree struct {
+	root string
+}
+
+func (t dirTree) files() ([]sourceFile, error) {
+	var files []sourceFile
+	err := filepath.Walk(t.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if vcsMetadata[info.Name()] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, t.sourceFile(filepath.ToSlash(rel)))
+		return nil
+	})
+	return files, err
+}
+
+func (t dirTree) file(name string) (sourceFile, error) {
+	info, err := os.Stat(filepath.Join(t.root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return sourceFile{}, errFileNotFound
+	}
+	if err != nil {
+		return sourceFile{}, err
+	}
+	return t.sourceFile(name), nil
+}
+
+func (t dirTree) sourceFile(name string) sourceFile {
+	return sourceFile{name, func() (string, error) {
+		b, err := ioutil.ReadFile(filepath.Join(t.root, filepath.FromSlash(name)))
+		return string(b), err
+	}}
+}
+
+func (t dirTree) dirEntries(d string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(t.root, filepath.FromSlash(d)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if !vcsMetadata[fi.Name()] {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// goGetVCSs are the version control systems go get knows, and "mod" for a
+// module proxy.
+var goGetVCSs = map[string]bool{
+	"bzr":    true,
+	"fossil": true,
+	"git":    true,
+	"hg":     true,
+	"svn":    true,
+	"mod":    true,
+}
+
+// validatePage checks that go get, fetching importPath, would find the
+// go-import tag want in the page contents, and would accept it.
+func validatePage(importPath string, contents []byte, want GoImport) error {
+	imports, err := parseMetaGoImports(bytes.NewReader(contents))
+	if err != nil {
+		return fmt.Errorf("parsing meta tags: %s", err)
+	}
+
+	var matches []GoImport
+	for _, m := range imports {
+		if importPath == m.ImportPrefix || strings.HasPrefix(importPath, m.ImportPrefix+"/") {
+			matches = append(matches, m)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return fmt.Errorf("no go-import meta tag matching %s", importPath)
+	case len(matches) > 1:
+		return fmt.Errorf("multiple go-import meta tags match %s", importPath)
+	case matches[0] != want:
+		return fmt.Errorf("go-import meta tag is %q, want %q", goImportContent(matches[0]), goImportContent(want))
+	}
+	if err := validateRepoRoot(want.RepoRoot); err != nil {
+		return fmt.Errorf("invalid repo root %q: %s", want.RepoRoot, err)
+	}
+	if !goGetVCSs[want.VCS] {
+		return fmt.Errorf("unknown vcs %q", want.VCS)
+	}
+	return nil
+}
+
+// validateRepoRoot returns an error if go get would reject repoRoot as the
+// root of a repository. It is adapted from cmd/go/internal/vcs/vcs.go.
+func validateRepoRoot(repoRoot string) error {
+	u, err := url.Parse(repoRoot)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return errors.New("no scheme")
+	}
+	if u.Scheme == "file" {
+		return errors.New("file scheme disallowed")
+	}
+	return nil
+}
+
+func goImportContent(m GoImport) string {
+	return strings.TrimSpace(strings.Join([]string{m.ImportPrefix, m.VCS, m.RepoRoot, m.Subdir}, " "))
+}
+
+// parseMetaGoImports returns the go-import meta tags in the HTML read from
+// r, parsed the way cmd/go parses them. It is adapted from
+// cmd/go/internal/vcs/discovery.go.
+func parseMetaGoImports(r io.Reader) ([]GoImport, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charsetReader
+	d.Strict = false
+	var imports []GoImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err != io.EOF && len(imports) == 0 {
+				return nil, err
+			}
+			break
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		if attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 3 || len(f) == 4 {
+			m := GoImport{
+				ImportPrefix: f[0],
+				VCS:          f[1],
+				RepoRoot:     f[2],
+			}
+			if len(f) == 4 {
+				m.Subdir = f[3]
+			}
+			imports = append(imports, m)
+		}
+	}
+	return imports, nil
+}
+
+// charsetReader returns a reader that converts from the given charset to
+// UTF-8. Like cmd/go, it only supports UTF-8 and ASCII.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "ascii":
+		return input, nil
+	default:
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+}
+
+// attrValue returns the attribute value for the case-insensitive key
+// name, or the empty string if the key is not found.
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
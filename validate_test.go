@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePage(t *testing.T) {
+	page := func(m GoImport) []byte {
+		return []byte(`<!DOCTYPE html><html><head><meta name="go-import" content="` + goImportContent(m) + `"></head></html>`)
+	}
+	tests := []struct {
+		name    string
+		m       GoImport
+		wantErr string // empty if valid
+	}{
+		{"https", GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "https://github.com/user/r"}, ""},
+		{"ssh", GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "ssh://git@example.com/r.git"}, ""},
+		{"module proxy", GoImport{ImportPrefix: "example.org/r", VCS: "mod", RepoRoot: "https://proxy.example.com"}, ""},
+		{"scp-like", GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "git@github.com:user/r.git"}, "invalid repo root"},
+		{"local path", GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "/tmp/r"}, "no scheme"},
+		{"file", GoImport{ImportPrefix: "example.org/r", VCS: "git", RepoRoot: "file:///tmp/r.git"}, "file scheme disallowed"},
+		{"unknown vcs", GoImport{ImportPrefix: "example.org/r", VCS: "darcs", RepoRoot: "https://example.com/r"}, `unknown vcs "darcs"`},
+	}
+	for _, tt := range tests {
+		err := validatePage("example.org/r/pkg", page(tt.m), tt.m)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%s: got no error, want %q", tt.name, tt.wantErr)
+		case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%s: got error %q, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	git "gopkg.in/src-d/go-git.v3"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	gitcore "gopkg.in/src-d/go-git.v3/core"
+)
+
+// A treeRef names a tree of a repository: the head of a branch, a tag or
+// a revision. At most one is set; the zero treeRef names the head of the
+// default branch.
+type treeRef struct {
+	branch string
+	tag    string
+	rev    string
+}
+
+// name returns the name of the branch or tag, or the revision, which the
+// commands of most version control systems accept alike.
+func (r treeRef) name() string {
+	switch {
+	case r.rev != "":
+		return r.rev
+	case r.tag != "":
+		return r.tag
+	}
+	return r.branch
+}
+
+// nameOr returns the name of the branch or tag, or the revision, or def if
+// r is the zero treeRef.
+func (r treeRef) nameOr(def string) string {
+	if r == (treeRef{}) {
+		return def
+	}
+	return r.name()
+}
+
+// fullName returns the full name of the branch or tag, as in
+// refs/heads/main, or the revision, with def as the default branch.
+func (r treeRef) fullName(def string) string {
+	switch {
+	case r.rev != "":
+		return r.rev
+	case r.tag != "":
+		return "refs/tags/" + r.tag
+	case r.branch != "":
+		return "refs/heads/" + r.branch
+	}
+	return "refs/heads/" + def
+}
+
+func (r treeRef) String() string {
+	switch {
+	case r.rev != "":
+		return "revision " + r.rev
+	case r.tag != "":
+		return "tag " + r.tag
+	case r.branch != "":
+		return "branch " + r.branch
+	}
+	return "default branch"
+}
+
+// A vcsBackend fetches the trees of a repository.
+type vcsBackend interface {
+	// tree fetches the tree named by ref and returns it and its revision.
+	tree(ref treeRef) (sourceTree, string, error)
+	// close removes any temporary files.
+	close() error
+}
+
+// backends maps the version control systems supported by -vcs to
+// functions that return a backend for the repository.
+var backends = map[string]func(repoURL string) (vcsBackend, error){
+	"git":    newGitBackend,
+	"hg":     newCmdBackend(vcsHg),
+	"svn":    newCmdBackend(vcsSvn),
+	"bzr":    newCmdBackend(vcsBzr),
+	"fossil": newCmdBackend(vcsFossil),
+}
+
+// gitBackend fetches trees over the network with go-git.
+type gitBackend struct {
+	repo  *git.Repository
+	url   string       // as given, against which submodule URLs are resolved
+	disk  *diskStorage // the repository's storage with -storage disk
+	subs  []vcsBackend // of the submodules fetched
+	local bool         // whether the repository is on disk, as given
+}
+
+func newGitBackend(repoURL string) (vcsBackend, error) {
+	g := &gitBackend{url: repoURL}
+	var err error
+	if dir, ok := localRepoPath(repoURL); ok {
+		g.local = true
+		repoURL, err = installLocalProtocol(dir)
+	} else if noFetch {
+		return nil, fmt.Errorf("can't fetch %s with -no-fetch", repoURL)
+	} else if cacheDir != "" {
+		// Read the repository from its mirror in the cache.
+		if dir, err = cachedRepo(repoURL); err == nil {
+			repoURL, err = installLocalProtocol(dir)
+		}
+	} else if r, ok := parseSSHURL(repoURL); ok {
+		repoURL, err = installSSHProtocol(r)
+	} else if u, uerr := url.Parse(repoURL); uerr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		installHTTPProtocol(u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g.repo, err = git.NewRepository(repoURL, httpAuth(repoURL)); err != nil {
+		return nil, err
+	}
+	if storageKind == "disk" {
+		if g.disk, err = newDiskStorage(); err != nil {
+			return nil, err
+		}
+		g.repo.Storage = g.disk
+	}
+	return g, nil
+}
+
+func (g *gitBackend) tree(r treeRef) (sourceTree, string, error) {
+	remote := g.repo.Remotes[git.DefaultRemoteName]
+	err := remote.Connect()
+	if err != nil {
+		return nil, "", fmt.Errorf("pulling %s: %s", r, err)
+	}
+	var ref string
+	switch {
+	case r.rev != "":
+		// A commit needn't be the head of a ref, but the remote must
+		// allow it to be fetched, as most hosts do.
+		if !fullHashRe.MatchString(r.rev) {
+			return nil, "", fmt.Errorf("invalid revision %q: want a full commit hash", r.rev)
+		}
+		ref = r.rev
+	case r.tag != "":
+		ref = "refs/tags/" + r.tag
+	case r.branch != "":
+		ref = "refs/heads/" + r.branch
+	default:
+		ref, err = remoteDefaultBranch(remote)
+		if err != nil && g.local && remote.Info().Head != (gitcore.Hash{}) {
+			// A checkout, such as one made by CI, may have a detached
+			// HEAD, whose commit is used then.
+			ref, err = "HEAD", nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("pulling %s: %s", r, err)
+		}
+		debugf("default branch is %s", ref)
+	}
+	var want gitcore.Hash
+	if r.rev != "" {
+		want = gitcore.NewHash(r.rev)
+	} else if ref == "HEAD" {
+		want = remote.Info().Head
+	} else if want, err = remote.Ref(ref); err != nil {
+		return nil, "", fmt.Errorf("pulling %s: %s", r, err)
+	}
+	// An annotated tag is wanted itself, as in releases, but its tree is
+	// that of the commit it points to.
+	head := want
+	if peeled, ok := remote.Refs()[ref+"^{}"]; ok {
+		head = peeled
+	}
+
+	// Pull the ref as go-git's Repository.Pull does, but decoding the
+	// packfile according to -storage.
+	debugf("pulling %s", ref)
+	req := &common.GitUploadPackRequest{}
+	req.Want(want)
+	partial := false
+	if treeOnly {
+		// As git does, fetch the blobs too if the remote can't filter
+		// them out.
+		if partial = canFetchTreesOnly(remote.Capabilities(), g.url); partial {
+			treeRequests[req] = true
+			defer delete(treeRequests, req)
+		} else {
+			warnf("%s doesn't support fetching trees only; fetching %s with its files", g.url, r)
+		}
+	}
+	rc, err := remote.Fetch(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("pulling %s: %s", r, err)
+	}
+	defer rc.Close()
+	if err := decodePack(rc, g.repo.Storage); err != nil {
+		return nil, "", fmt.Errorf("pulling %s: %s", r, err)
+	}
+	headCommit, err := g.repo.Commit(head)
+	if err != nil {
+		return nil, "", fmt.Errorf("getting HEAD commit: %s", err)
+	}
+	t := gitTree{g.repo, headCommit.Tree(), partial}
+	if !withSubmodules {
+		return t, head.String(), nil
+	}
+	st, err := g.submodules(t)
+	if err != nil {
+		return nil, "", err
+	}
+	return st, head.String(), nil
+}
+
+func (g *gitBackend) close() error {
+	var err error
+	for _, b := range g.subs {
+		if cerr := b.close(); err == nil {
+			err = cerr
+		}
+	}
+	if g.disk != nil {
+		if cerr := g.disk.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+var fullHashRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// defaultBranch returns the name of the default branch of the git
+// repository, and reports whether the backend knows it.
+func defaultBranch(b vcsBackend) (string, bool) {
+	switch b := b.(type) {
+	case *gitBackend:
+		ref, err := remoteDefaultBranch(b.repo.Remotes[git.DefaultRemoteName])
+		return shortBranch(ref), err == nil
+	case *cmdBackend:
+		return b.defaultBranch, b.defaultBranch != ""
+	}
+	return "", false
+}
+
+// remoteDefaultBranch returns the default branch of the connected remote:
+// the branch its HEAD points to. For remotes that don't advertise it, such
+// as old servers, the branch is guessed as git clone does: the branch at
+// the commit of HEAD, preferring main and then master.
+func remoteDefaultBranch(remote *git.Remote) (string, error) {
+	if ref := remote.DefaultBranch(); ref != "" {
+		return ref, nil
+	}
+	head := remote.Info().Head
+	var branches []string
+	for name, h := range remote.Refs() {
+		if strings.HasPrefix(name, "refs/heads/") && h == head {
+			branches = append(branches, name)
+		}
+	}
+	if len(branches) == 0 {
+		return "", errors.New("can't determine the default branch of the remote; use -branch")
+	}
+	sort.Strings(branches)
+	for _, ref := range []string{"refs/heads/main", "refs/heads/master"} {
+		for _, b := range branches {
+			if b == ref {
+				return ref, nil
+			}
+		}
+	}
+	return branches[0], nil
+}
+
+// A vcsCmd describes how to check out a repository with the command of a
+// version control system, in the manner of cmd/go's vcsCmd.
+type vcsCmd struct {
+	cmd string // name of the binary
+	// checkout returns the arguments of the commands, run in order, that
+	// check out the tree named by ref into the directory dir. The commands
+	// are run in the parent of dir.
+	checkout func(repoURL string, ref treeRef, dir string) ([][]string, error)
+	// revision are the arguments of the command, run in the checkout,
+	// that prints the revision checked out.
+	revision []string
+	// parseRevision, if set, extracts the revision from the output of the
+	// revision command.
+	parseRevision func(out string) string
+	// defaultBranch, if set, are the arguments of the command, run in a
+	// checkout of the default branch, that prints the branch's name.
+	defaultBranch []string
+	// env, if set, returns the extra environment of the commands.
+	env func(repoURL string) []string
+}
+
+// vcsGit checks out git repositories with the git command, for -use-git-binary,
+// so that its configuration, such as credential helpers, proxies and
+// GIT_SSH_COMMAND, applies. The credentials metaimport would use are
+// passed to it too.
+var vcsGit = &vcsCmd{
+	cmd: "git",
+	checkout: func(repoURL string, ref treeRef, dir string) ([][]string, error) {
+		var depth []string
+		if fetchDepth > 0 {
+			depth = []string{fmt.Sprintf("--depth=%d", fetchDepth)}
+		}
+		var cmds [][]string
+		if ref.rev != "" {
+			// clone can't check out a revision, so fetch it alone.
+			cmds = [][]string{
+				{"init", "--quiet", "--", dir},
+				{"-C", dir, "remote", "add", "origin", repoURL},
+				append(append([]string{"-C", dir, "fetch", "--quiet"}, depth...), "origin", ref.rev),
+				{"-C", dir, "checkout", "--quiet", "FETCH_HEAD"},
+			}
+		} else {
+			args := append([]string{"clone", "--quiet", "--single-branch", "--no-tags"}, depth...)
+			if name := ref.name(); name != "" {
+				args = append(args, "--branch", name)
+			}
+			cmds = [][]string{append(args, "--", repoURL, dir)}
+		}
+		if withSubmodules {
+			cmds = append(cmds, append([]string{"-C", dir, "submodule", "--quiet", "update", "--init", "--recursive"}, depth...))
+		}
+		return cmds, nil
+	},
+	revision:      []string{"rev-parse", "HEAD"},
+	defaultBranch: []string{"symbolic-ref", "--short", "HEAD"},
+	env:           gitEnv,
+}
+
+var vcsHg = &vcsCmd{
+	cmd: "hg",
+	checkout: func(repoURL string, ref treeRef, dir string) ([][]string, error) {
+		args := []string{"clone", "--noninteractive"}
+		if name := ref.name(); name != "" {
+			args = append(args, "--updaterev", name)
+		}
+		return [][]string{append(args, repoURL, dir)}, nil
+	},
+	revision: []string{"log", "--rev", ".", "--template", "{node}"},
+}
+
+// vcsSvn checks out the branch or tag at the path given as the branch,
+// relative to the repository URL, such as "branches/v2" or "tags/v1.0.0".
+// A tag is checked out from the conventional tags directory. The default
+// branch is the repository URL itself.
+var vcsSvn = &vcsCmd{
+	cmd: "svn",
+	checkout: func(repoURL string, ref treeRef, dir string) ([][]string, error) {
+		path := ref.branch
+		if ref.tag != "" {
+			path = "tags/" + ref.tag
+		}
+		if path != "" {
+			repoURL = strings.TrimSuffix(repoURL, "/") + "/" + strings.Trim(path, "/")
+		}
+		args := []string{"checkout", "--non-interactive"}
+		if ref.rev != "" {
+			args = append(args, "--revision", ref.rev)
+		}
+		return [][]string{append(args, repoURL, dir)}, nil
+	},
+	revision: []string{"info", "--show-item", "revision"},
+}
+
+// vcsBzr checks out a Bazaar branch, such as a Launchpad project's
+// lp:project. Bazaar branches are separate repositories with URLs of their
+// own, so a branch can't be selected by name.
+var vcsBzr = &vcsCmd{
+	cmd: "bzr",
+	checkout: func(repoURL string, ref treeRef, dir string) ([][]string, error) {
+		if ref.branch != "" {
+			return nil, fmt.Errorf("bzr branches can't be selected by name; use the branch's URL as the repository")
+		}
+		args := []string{"branch"}
+		if ref.tag != "" {
+			args = append(args, "--revision", "tag:"+ref.tag)
+		} else if ref.rev != "" {
+			args = append(args, "--revision", ref.rev)
+		}
+		return [][]string{append(args, repoURL, dir)}, nil
+	},
+	revision: []string{"version-info", "--custom", "--template={revision_id}"},
+}
+
+// vcsFossil clones a Fossil repository into a repository file next to the
+// checkout, and opens the branch, trunk by default, from it.
+var vcsFossil = &vcsCmd{
+	cmd: "fossil",
+	checkout: func(repoURL string, ref treeRef, dir string) ([][]string, error) {
+		open := []string{"open", "--workdir", dir, dir + ".fossil"}
+		if name := ref.name(); name != "" {
+			open = append(open, name)
+		}
+		return [][]string{{"clone", repoURL, dir + ".fossil"}, open}, nil
+	},
+	revision: []string{"info"},
+	parseRevision: func(out string) string {
+		// The line is "checkout:     <hash> <date>".
+		for _, line := range strings.Split(out, "\n") {
+			if f := strings.Fields(line); len(f) > 1 && f[0] == "checkout:" {
+				return f[1]
+			}
+		}
+		return ""
+	},
+}
+
+// cmdBackend fetches trees by checking out the repository into a temporary
+// directory with the command of its version control system.
+type cmdBackend struct {
+	vcs     *vcsCmd
+	repoURL string
+	tmp     string // directory of the checkouts, made by the first call to tree
+	n       int    // number of checkouts
+	// defaultBranch is the name of the default branch, if known.
+	defaultBranch string
+}
+
+// newCmdBackend returns a function that makes a backend using vcs.
+func newCmdBackend(vcs *vcsCmd) func(string) (vcsBackend, error) {
+	return func(repoURL string) (vcsBackend, error) {
+		if _, err := exec.LookPath(vcs.cmd); err != nil {
+			return nil, fmt.Errorf("%s command not found", vcs.cmd)
+		}
+		if strings.HasPrefix(repoURL, "-") {
+			// Don't let the URL be taken for an option.
+			return nil, fmt.Errorf("invalid repository URL %q", repoURL)
+		}
+		return &cmdBackend{vcs: vcs, repoURL: repoURL}, nil
+	}
+}
+
+func (c *cmdBackend) tree(ref treeRef) (sourceTree, string, error) {
+	if c.tmp == "" {
+		tmp, err := ioutil.TempDir("", "metaimport")
+		if err != nil {
+			return nil, "", err
+		}
+		c.tmp = tmp
+	}
+	c.n++
+	dir := filepath.Join(c.tmp, fmt.Sprint(c.n))
+	rev, err := c.checkout(ref, dir)
+	if err != nil {
+		// Don't leave the partial checkout behind. The directory of the
+		// checkouts stays, since earlier trees are in it; close removes it.
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	return dirTree{dir}, rev, nil
+}
+
+func (c *cmdBackend) checkout(ref treeRef, dir string) (string, error) {
+	cmds, err := c.vcs.checkout(c.repoURL, ref, dir)
+	if err != nil {
+		return "", err
+	}
+	for _, args := range cmds {
+		if _, err := c.run(c.tmp, args); err != nil {
+			return "", err
+		}
+	}
+	rev, err := c.run(dir, c.vcs.revision)
+	if err != nil {
+		return "", err
+	}
+	if ref == (treeRef{}) && c.vcs.defaultBranch != nil {
+		b, err := c.run(dir, c.vcs.defaultBranch)
+		if err != nil {
+			return "", err
+		}
+		c.defaultBranch = strings.TrimSpace(b)
+		debugf("default branch is %s", c.defaultBranch)
+	}
+	if c.vcs.parseRevision != nil {
+		return c.vcs.parseRevision(rev), nil
+	}
+	return strings.TrimSpace(rev), nil
+}
+
+func (c *cmdBackend) run(dir string, args []string) (string, error) {
+	debugf("running %s %s in %s", c.vcs.cmd, strings.Join(args, " "), dir)
+	cmd := exec.Command(c.vcs.cmd, args...)
+	cmd.Dir = dir
+	if c.vcs.env != nil {
+		cmd.Env = append(os.Environ(), c.vcs.env(c.repoURL)...)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %s: %s", c.vcs.cmd, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (c *cmdBackend) close() error {
+	if c.tmp == "" {
+		return nil
+	}
+	return os.RemoveAll(c.tmp)
+}
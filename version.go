@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+const versionHelp = `usage: metaimport version [-check-update]
+
+version prints the version of metaimport and the VCS revision it was built
+from, if known. Release builds set the version with
+	-ldflags "-X main.version=v1.2.3"
+
+Flags
+   -check-update  Report whether a newer release is available through
+                  proxy.golang.org (default: false).
+`
+
+// modulePathSelf is the module path of metaimport, used to look up
+// releases.
+const modulePathSelf = "github.com/nishanths/metaimport"
+
+// version is the release version, set at link time. If unset, the version
+// recorded in the build info is used, if any.
+var version = ""
+
+// buildVersion returns the version and VCS revision of the running binary.
+// Either may be empty if unknown.
+func buildVersion() (v, revision string) {
+	v = version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, ""
+	}
+	if v == "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "+dirty"
+	}
+	return v, revision
+}
+
+func runVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, versionHelp)
+		os.Exit(2)
+	}
+	checkUpdate := fs.Bool("check-update", false, "")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+	}
+
+	v, revision := buildVersion()
+	if v == "" {
+		v = "devel"
+	}
+	fmt.Printf("metaimport %s", v)
+	if revision != "" {
+		fmt.Printf(" (%s)", revision)
+	}
+	fmt.Printf(" %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	if !*checkUpdate {
+		return
+	}
+	latest, err := proxyLatest(modulePathSelf)
+	if err != nil {
+		log.Fatalf("checking for update: %s", err)
+	}
+	if isSemver(v) && compareSemver(latest, v) <= 0 {
+		fmt.Printf("metaimport %s is the latest release\n", v)
+		return
+	}
+	fmt.Printf("metaimport %s is available: go install %s@%s\n", latest, modulePathSelf, latest)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	git "gopkg.in/src-d/go-git.v3"
+	"gopkg.in/src-d/go-git.v3/clients/common"
+	gitcore "gopkg.in/src-d/go-git.v3/core"
+)
+
+// A release is a tag of the repository that is a semantic version.
+type release struct {
+	Version string
+	Time    time.Time // commit time of the tagged commit
+}
+
+// releases returns the repository's releases, newest version first. The
+// commits the tags point to are fetched from the default remote, which
+// must be connected.
+func releases(repo *git.Repository) ([]release, error) {
+	remote := repo.Remotes[git.DefaultRemoteName]
+	refs := remote.Refs()
+
+	commits := make(map[string]gitcore.Hash) // version -> tagged commit
+	req := &common.GitUploadPackRequest{}
+	for name, h := range refs {
+		version := strings.TrimPrefix(name, "refs/tags/")
+		if version == name || !isSemver(version) {
+			continue
+		}
+		// Want the tag itself, since over SSH the remote only sends
+		// objects named by its refs, but for annotated tags keep the
+		// commit the tag points to, which the remote also advertises.
+		req.Want(h)
+		if peeled, ok := refs[name+"^{}"]; ok {
+			h = peeled
+		}
+		commits[version] = h
+	}
+	if len(commits) == 0 {
+		return nil, nil
+	}
+
+	r, err := remote.Fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching tags: %s", err)
+	}
+	defer r.Close()
+	if err := decodePack(r, repo.Storage); err != nil {
+		return nil, fmt.Errorf("decoding tags: %s", err)
+	}
+
+	var rels []release
+	for version, h := range commits {
+		c, err := repo.Commit(h)
+		if err != nil {
+			return nil, fmt.Errorf("getting commit for %s: %s", version, err)
+		}
+		rels = append(rels, release{version, c.Committer.When})
+	}
+	sort.Slice(rels, func(i, j int) bool {
+		return compareSemver(rels[i].Version, rels[j].Version) > 0
+	})
+	return rels, nil
+}
+
+// majorSuffixRe matches the /vN suffix of the path of a module of major
+// version 2 or higher.
+var majorSuffixRe = regexp.MustCompile(`/v([2-9]|[1-9][0-9]+)$`)
+
+// moduleReleases returns the releases that are versions of the module
+// path: those of the major version of its /vN suffix, or v0 and v1 if it
+// has none. The go command takes the tags of other major versions to be of
+// other module paths, or +incompatible versions, so pkg.go.dev has no page
+// for them under the module path.
+func moduleReleases(module string, rels []release) []release {
+	major := "1"
+	if m := majorSuffixRe.FindStringSubmatch(module); m != nil {
+		major = m[1]
+	}
+	var kept []release
+	for _, r := range rels {
+		v := semverRe.FindStringSubmatch(r.Version)[1]
+		if v == major || (v == "0" && major == "1") {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// semverRe matches the semantic versions the go command accepts as module
+// versions: those without build metadata.
+var semverRe = regexp.MustCompile(`^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?$`)
+
+func isSemver(v string) bool {
+	return semverRe.MatchString(v)
+}
+
+// compareSemver returns -1, 0 or 1 as the valid semantic version a is
+// less than, equal to or greater than b.
+func compareSemver(a, b string) int {
+	ma, mb := semverRe.FindStringSubmatch(a), semverRe.FindStringSubmatch(b)
+	for i := 1; i <= 3; i++ {
+		if c := compareNumeric(ma[i], mb[i]); c != 0 {
+			return c
+		}
+	}
+	// A version without a prerelease is greater than one with.
+	switch pa, pb := ma[4], mb[4]; {
+	case pa == pb:
+		return 0
+	case pa == "":
+		return 1
+	case pb == "":
+		return -1
+	default:
+		return comparePrerelease(pa, pb)
+	}
+}
+
+func comparePrerelease(a, b string) int {
+	fa, fb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(fa) && i < len(fb); i++ {
+		if fa[i] == fb[i] {
+			continue
+		}
+		_, errA := strconv.ParseUint(fa[i], 10, 64)
+		_, errB := strconv.ParseUint(fb[i], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			return compareNumeric(fa[i], fb[i])
+		case errA == nil: // numeric identifiers are lower
+			return -1
+		case errB == nil:
+			return 1
+		case fa[i] < fb[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareNumeric(strconv.Itoa(len(fa)), strconv.Itoa(len(fb)))
+}
+
+// compareNumeric compares decimal strings without leading zeros.
+func compareNumeric(a, b string) int {
+	switch {
+	case len(a) != len(b):
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionsFile returns the page listing the releases of the module.
+func versionsFile(module string, rels []release) (File, error) {
+	f := File{path: path.Join(module, "@versions", "index.html")}
+	err := versionsTmpl.Execute(&f.contents, struct {
+		Module   string
+		Releases []release
+	}{module, rels})
+	return f, err
+}
+
+var versionsTmpl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<title>{{ .Module }} versions</title>
+	</head>
+	<body>
+		<h1>{{ .Module }}</h1>
+		{{ if .Releases -}}
+		<ul>
+		{{- range .Releases }}
+			<li><a href="https://pkg.go.dev/{{ $.Module }}@{{ .Version }}">{{ .Version }}</a> {{ .Time.UTC.Format "2006-01-02" }}</li>
+		{{- end }}
+		</ul>
+		{{- else -}}
+		No versions.
+		{{- end }}
+	</body>
+</html>
+`))
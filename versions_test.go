@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModuleReleases(t *testing.T) {
+	var rels []release
+	for _, v := range []string{"v3.0.0", "v2.1.0", "v2.0.0", "v1.2.0", "v1.2.0-rc.1", "v0.1.0", "v10.0.0"} {
+		rels = append(rels, release{Version: v})
+	}
+	tests := []struct {
+		module string
+		want   []string
+	}{
+		{"example.org/r", []string{"v1.2.0", "v1.2.0-rc.1", "v0.1.0"}},
+		{"example.org/r/v2", []string{"v2.1.0", "v2.0.0"}},
+		{"example.org/r/v10", []string{"v10.0.0"}},
+		{"example.org/r/v1", []string{"v1.2.0", "v1.2.0-rc.1", "v0.1.0"}}, // not a major version suffix
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range moduleReleases(tt.module, rels) {
+			got = append(got, r.Version)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("moduleReleases(%q) = %q, want %q", tt.module, got, tt.want)
+		}
+	}
+}
+
+func TestIsSemver(t *testing.T) {
+	for v, want := range map[string]bool{
+		"v1.2.3":        true,
+		"v1.2.3-rc.1":   true,
+		"v0.0.0":        true,
+		"1.2.3":         false,
+		"v1.2":          false,
+		"v01.2.3":       false,
+		"v1.2.3+build":  false, // the go command rejects build metadata
+		"sub/v1.2.3":    false,
+		"v1.2.3-rc.1+b": false,
+	} {
+		if got := isSemver(v); got != want {
+			t.Errorf("isSemver(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
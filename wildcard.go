@@ -0,0 +1,16 @@
+package main
+
+// An unfetchedBackend stands for the repository with -single-page, which
+// isn't fetched. Its trees are empty, so that only the page for the import
+// prefix is generated.
+type unfetchedBackend struct{}
+
+func (unfetchedBackend) tree(ref treeRef) (sourceTree, string, error) { return emptyTree{}, "", nil }
+func (unfetchedBackend) close() error                                 { return nil }
+
+// emptyTree is a sourceTree without any files.
+type emptyTree struct{}
+
+func (emptyTree) files() ([]sourceFile, error)          { return nil, nil }
+func (emptyTree) file(name string) (sourceFile, error)  { return sourceFile{}, errFileNotFound }
+func (emptyTree) dirEntries(d string) ([]string, error) { return nil, nil }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A module is a Go module in the repository.
+type module struct {
+	dir  string // slash-separated, relative to the repository root
+	path string // module path
+}
+
+// workspaceModules returns the modules used by the go.work file at the root
+// of tree, or nil if there is no go.work file.
+func workspaceModules(tree sourceTree) ([]module, error) {
+	work, err := tree.file("go.work")
+	if err == errFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines, err := work.lines()
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []module
+	for _, dir := range useDirectives(lines) {
+		dir = path.Clean(dir)
+		if path.IsAbs(dir) || strings.HasPrefix(dir, "../") || dir == ".." {
+			// Outside the repository.
+			continue
+		}
+		modFile, err := tree.file(path.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("reading go.mod in %s: %s", dir, err)
+		}
+		modLines, err := modFile.lines()
+		if err != nil {
+			return nil, fmt.Errorf("reading go.mod in %s: %s", dir, err)
+		}
+		p := modulePath(modLines)
+		if p == "" {
+			return nil, fmt.Errorf("no module directive in %s", path.Join(dir, "go.mod"))
+		}
+		mods = append(mods, module{dir, p})
+	}
+	return mods, nil
+}
+
+// useDirectives returns the directories named by the use directives in the
+// lines of a go.work file.
+func useDirectives(lines []string) []string {
+	var dirs []string
+	inBlock := false
+	for _, line := range lines {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inBlock && fields[0] == ")":
+			inBlock = false
+		case inBlock:
+			dirs = append(dirs, unquote(fields[0]))
+		case fields[0] == "use" && len(fields) > 1 && fields[1] == "(":
+			inBlock = true
+		case fields[0] == "use" && len(fields) > 1:
+			dirs = append(dirs, unquote(fields[1]))
+		}
+	}
+	return dirs
+}
+
+// modulePath returns the path in the module directive in the lines of a
+// go.mod file, or the empty string if there is none.
+func modulePath(lines []string) string {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return unquote(fields[1])
+		}
+	}
+	return ""
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// containingModule returns the innermost module in mods containing the
+// directory d, and whether there is one.
+func containingModule(mods []module, d string) (module, bool) {
+	depth := func(dir string) int {
+		if dir == "." {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+	var best module
+	found := false
+	for _, m := range mods {
+		if m.dir != "." && d != m.dir && !strings.HasPrefix(d, m.dir+"/") {
+			continue
+		}
+		if !found || depth(m.dir) > depth(best.dir) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}